@@ -12,6 +12,8 @@ type Node interface {
 	// 返回与该节点关联的字面量(该方法仅用于调试和测试)
 	TokenLiteral() string
 	String() string
+	// 节点在源码中的位置(用于报错)
+	Pos() token.Position
 }
 type Statement interface {
 	Node
@@ -46,32 +48,53 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 // 标识符
 type Identifier struct {
 	Token token.Token // token.IDENT词法单元
 	Value string
+	// quote中被绑定的名字可以写成unquote(x),如 let unquote(name) = 1,展开时替换为求值结果
+	Unquote Expression
 }
 
 func (i *Identifier) expressionNode() {}
 
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
 
-func (i *Identifier) String() string { return i.Value }
+func (i *Identifier) String() string {
+	if i.Unquote != nil {
+		return "unquote(" + i.Unquote.String() + ")"
+	}
+	return i.Value
+}
 
 // let语句
 type LetStatement struct {
-	Token token.Token // token.LET词法单元
-	Name  *Identifier // 标识符
-	Value Expression  // 产生值的表达式
+	Token    token.Token // token.LET词法单元
+	Name     *Identifier // 标识符
+	Value    Expression  // 产生值的表达式
+	Doc      string      // 紧挨在let前面的///文档注释,多行用\n连接
+	Exported bool        // export let,模块导出的绑定
 }
 
 func (ls *LetStatement) statementNode() {}
 
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
 
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
+	if ls.Exported {
+		out.WriteString("export ")
+	}
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String())
 	out.WriteString(" = ")
@@ -92,6 +115,7 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -114,6 +138,7 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -129,8 +154,20 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+// 浮点数字面量
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
 type PrefixExpression struct {
 	Token    token.Token // 前缀词法单元 - !
 	Operator string      // 包含-或!的字符串
@@ -139,6 +176,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -160,6 +198,7 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -179,8 +218,40 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
+// 空值字面量 null
+type NullLiteral struct {
+	Token token.Token
+}
+
+func (n *NullLiteral) expressionNode()      {}
+func (n *NullLiteral) TokenLiteral() string { return n.Token.Literal }
+func (n *NullLiteral) Pos() token.Position  { return n.Token.Pos }
+func (n *NullLiteral) String() string       { return "null" }
+
+// 赋值表达式 x = 1, x += 1
+type AssignExpression struct {
+	Token    token.Token // 赋值运算符词法单元
+	Target   Expression  // 被赋值的标识符或索引表达式(arr[i], hash[key])
+	Operator string      // = += -= *= /=
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Token.Pos }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+
+	return out.String()
+}
+
 type BlockStatement struct {
 	Token      token.Token // '{'词法单元
 	Statements []Statement
@@ -188,6 +259,7 @@ type BlockStatement struct {
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -207,6 +279,7 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -223,6 +296,54 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// 模式匹配 match (x) { 1 => "one", [a, b] if a > b => a, _ => null }
+type MatchExpression struct {
+	Token   token.Token // 'match'词法单元
+	Subject Expression  // 被匹配的值
+	Arms    []*MatchArm
+}
+
+// match的一个分支
+type MatchArm struct {
+	Pattern Expression      // 字面量、数组、哈希表、绑定变量或通配符_
+	Guard   Expression      // if守卫条件,可以为nil
+	Body    *BlockStatement // 分支体
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Pos() token.Position  { return me.Token.Pos }
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, arm := range me.Arms {
+		arms = append(arms, arm.String())
+	}
+
+	out.WriteString("match")
+	out.WriteString(me.Subject.String())
+	out.WriteString(" {")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+func (ma *MatchArm) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ma.Pattern.String())
+	if ma.Guard != nil {
+		out.WriteString(" if ")
+		out.WriteString(ma.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(ma.Body.String())
+
+	return out.String()
+}
+
 type FunctionLiteral struct {
 	Token      token.Token // 'fn'词法单元
 	Parameters []*Identifier
@@ -231,6 +352,7 @@ type FunctionLiteral struct {
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -256,6 +378,7 @@ type CallExpression struct {
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Token.Pos }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -267,7 +390,7 @@ func (ce *CallExpression) String() string {
 	out.WriteString((ce.Function.String()))
 	out.WriteString("(")
 	out.WriteString(strings.Join(args, ", "))
-	out.WriteString(") ")
+	out.WriteString(")")
 
 	return out.String()
 }
@@ -279,8 +402,32 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
+// 插值字符串 "total: ${sum(xs)}"
+type TemplateLiteral struct {
+	Token token.Token  // token.TEMPLATE词法单元
+	Parts []Expression // 文本片段(StringLiteral)和插值表达式
+}
+
+func (tl *TemplateLiteral) expressionNode()      {}
+func (tl *TemplateLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TemplateLiteral) Pos() token.Position  { return tl.Token.Pos }
+func (tl *TemplateLiteral) String() string {
+	var out bytes.Buffer
+
+	for _, part := range tl.Parts {
+		if str, ok := part.(*StringLiteral); ok {
+			out.WriteString(str.Value)
+		} else {
+			out.WriteString("${" + part.String() + "}")
+		}
+	}
+
+	return out.String()
+}
+
 type ArrayLiteral struct {
 	Token    token.Token // [词法单元
 	Elements []Expression
@@ -288,6 +435,7 @@ type ArrayLiteral struct {
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -311,6 +459,7 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -323,14 +472,46 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// 导入模块 use strings / use strings as s / use strings { split, join }
 type UseExpression struct {
-	Token    token.Token // 'use'词法单元
-	FileName string      // 导入的文件名
+	Token    token.Token   // 'use'词法单元
+	FileName string        // 导入的文件名
+	Alias    *Identifier   // as后的别名,没有时为nil
+	Names    []*Identifier // {}中选择导入的名字,没有时为nil
 }
 
 func (ue *UseExpression) expressionNode()      {}
 func (ue *UseExpression) TokenLiteral() string { return ue.Token.Literal }
-func (ue *UseExpression) String() string       { return ue.FileName }
+func (ue *UseExpression) Pos() token.Position  { return ue.Token.Pos }
+func (ue *UseExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("use " + ue.FileName)
+	if ue.Alias != nil {
+		out.WriteString(" as " + ue.Alias.String())
+	}
+	if ue.Names != nil {
+		names := []string{}
+		for _, name := range ue.Names {
+			names = append(names, name.String())
+		}
+		out.WriteString(" { " + strings.Join(names, ", ") + " }")
+	}
+
+	return out.String()
+}
+
+// 成员访问 s.split
+type MemberExpression struct {
+	Token  token.Token // '.'词法单元
+	Object Expression
+	Member *Identifier
+}
+
+func (me *MemberExpression) expressionNode()      {}
+func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MemberExpression) Pos() token.Position  { return me.Token.Pos }
+func (me *MemberExpression) String() string       { return me.Object.String() + "." + me.Member.String() }
 
 type HashLiteral struct {
 	Token token.Token // '{'词法单元
@@ -339,6 +520,7 @@ type HashLiteral struct {
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
@@ -358,6 +540,7 @@ type MacroLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
 	Body       *BlockStatement
+	Hygienic   bool // hygienic macro: 展开时重命名宏引入的绑定
 }
 
 func (ml *MacroLiteral) String() string {
@@ -368,7 +551,10 @@ func (ml *MacroLiteral) String() string {
 		params = append(params, p.String())
 	}
 
-	out.WriteString(ml.TokenLiteral())
+	if ml.Hygienic {
+		out.WriteString("hygienic ")
+	}
+	out.WriteString("macro")
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") ")
@@ -377,6 +563,7 @@ func (ml *MacroLiteral) String() string {
 	return out.String()
 }
 func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MacroLiteral) Pos() token.Position  { return ml.Token.Pos }
 func (ml *MacroLiteral) expressionNode()      {}
 
 type ForExpression struct {
@@ -387,6 +574,7 @@ type ForExpression struct {
 
 func (fl *ForExpression) expressionNode()      {}
 func (fl *ForExpression) TokenLiteral() string { return fl.Token.Literal }
+func (fl *ForExpression) Pos() token.Position  { return fl.Token.Pos }
 func (fl *ForExpression) String() string {
 	var out bytes.Buffer
 
@@ -398,12 +586,42 @@ func (fl *ForExpression) String() string {
 	return out.String()
 }
 
+// 范围循环 for (v range arr), for (i, v range arr), for (k, v range hash)
+type ForRangeExpression struct {
+	Token    token.Token     // 'for'词法单元
+	Key      *Identifier     // 下标或键,只写一个变量时为nil
+	Value    *Identifier     // 元素或值
+	Iterable Expression      // 被遍历的对象
+	Body     *BlockStatement // 循环体
+}
+
+func (fr *ForRangeExpression) expressionNode()      {}
+func (fr *ForRangeExpression) TokenLiteral() string { return fr.Token.Literal }
+func (fr *ForRangeExpression) Pos() token.Position  { return fr.Token.Pos }
+func (fr *ForRangeExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for(")
+	if fr.Key != nil {
+		out.WriteString(fr.Key.String())
+		out.WriteString(", ")
+	}
+	out.WriteString(fr.Value.String())
+	out.WriteString(" range ")
+	out.WriteString(fr.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fr.Body.String())
+
+	return out.String()
+}
+
 type BreakExpression struct {
 	Token token.Token // 'break'词法单元
 }
 
 func (bs *BreakExpression) expressionNode()      {}
 func (bs *BreakExpression) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakExpression) Pos() token.Position  { return bs.Token.Pos }
 func (bs *BreakExpression) String() string {
 	var out bytes.Buffer
 
@@ -419,6 +637,7 @@ type ContinueExpression struct {
 
 func (cs *ContinueExpression) expressionNode()      {}
 func (cs *ContinueExpression) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueExpression) Pos() token.Position  { return cs.Token.Pos }
 func (cs *ContinueExpression) String() string {
 	var out bytes.Buffer
 
@@ -0,0 +1,178 @@
+package ast
+
+// 深拷贝AST,Modify会原地修改节点,修改前需要拷贝的场景(如quote宏体)使用
+func Clone(node Node) Node {
+	switch node := node.(type) {
+	case *Program:
+		return &Program{Statements: cloneStatements(node.Statements)}
+	case *ExpressionStatement:
+		return &ExpressionStatement{Token: node.Token, Expression: cloneExpression(node.Expression)}
+	case *LetStatement:
+		return &LetStatement{
+			Token:    node.Token,
+			Name:     cloneIdentifier(node.Name),
+			Value:    cloneExpression(node.Value),
+			Doc:      node.Doc,
+			Exported: node.Exported,
+		}
+	case *ReturnStatement:
+		return &ReturnStatement{Token: node.Token, ReturnValue: cloneExpression(node.ReturnValue)}
+	case *BlockStatement:
+		return cloneBlock(node)
+	case *Identifier:
+		return cloneIdentifier(node)
+	case *IntegerLiteral:
+		clone := *node
+		return &clone
+	case *FloatLiteral:
+		clone := *node
+		return &clone
+	case *StringLiteral:
+		clone := *node
+		return &clone
+	case *Boolean:
+		clone := *node
+		return &clone
+	case *NullLiteral:
+		clone := *node
+		return &clone
+	case *UseExpression:
+		return &UseExpression{
+			Token:    node.Token,
+			FileName: node.FileName,
+			Alias:    cloneIdentifier(node.Alias),
+			Names:    cloneIdentifiers(node.Names),
+		}
+	case *BreakExpression:
+		clone := *node
+		return &clone
+	case *ContinueExpression:
+		clone := *node
+		return &clone
+	case *TemplateLiteral:
+		return &TemplateLiteral{Token: node.Token, Parts: cloneExpressions(node.Parts)}
+	case *PrefixExpression:
+		return &PrefixExpression{Token: node.Token, Operator: node.Operator, Right: cloneExpression(node.Right)}
+	case *InfixExpression:
+		return &InfixExpression{
+			Token:    node.Token,
+			Left:     cloneExpression(node.Left),
+			Operator: node.Operator,
+			Right:    cloneExpression(node.Right),
+		}
+	case *AssignExpression:
+		return &AssignExpression{
+			Token:    node.Token,
+			Target:   cloneExpression(node.Target),
+			Operator: node.Operator,
+			Value:    cloneExpression(node.Value),
+		}
+	case *IfExpression:
+		return &IfExpression{
+			Token:       node.Token,
+			Condition:   cloneExpression(node.Condition),
+			Consequence: cloneBlock(node.Consequence),
+			Alternative: cloneBlock(node.Alternative),
+		}
+	case *MatchExpression:
+		arms := make([]*MatchArm, len(node.Arms))
+		for i, arm := range node.Arms {
+			arms[i] = &MatchArm{
+				Pattern: cloneExpression(arm.Pattern),
+				Guard:   cloneExpression(arm.Guard),
+				Body:    cloneBlock(arm.Body),
+			}
+		}
+		return &MatchExpression{Token: node.Token, Subject: cloneExpression(node.Subject), Arms: arms}
+	case *ForExpression:
+		return &ForExpression{Token: node.Token, Condition: cloneExpression(node.Condition), Body: cloneBlock(node.Body)}
+	case *ForRangeExpression:
+		return &ForRangeExpression{
+			Token:    node.Token,
+			Key:      cloneIdentifier(node.Key),
+			Value:    cloneIdentifier(node.Value),
+			Iterable: cloneExpression(node.Iterable),
+			Body:     cloneBlock(node.Body),
+		}
+	case *FunctionLiteral:
+		return &FunctionLiteral{Token: node.Token, Parameters: cloneIdentifiers(node.Parameters), Body: cloneBlock(node.Body)}
+	case *MacroLiteral:
+		return &MacroLiteral{
+			Token:      node.Token,
+			Parameters: cloneIdentifiers(node.Parameters),
+			Body:       cloneBlock(node.Body),
+			Hygienic:   node.Hygienic,
+		}
+	case *CallExpression:
+		return &CallExpression{Token: node.Token, Function: cloneExpression(node.Function), Arguments: cloneExpressions(node.Arguments)}
+	case *ArrayLiteral:
+		return &ArrayLiteral{Token: node.Token, Elements: cloneExpressions(node.Elements)}
+	case *MemberExpression:
+		return &MemberExpression{Token: node.Token, Object: cloneExpression(node.Object), Member: cloneIdentifier(node.Member)}
+	case *IndexExpression:
+		return &IndexExpression{Token: node.Token, Left: cloneExpression(node.Left), Index: cloneExpression(node.Index)}
+	case *HashLiteral:
+		pairs := make(map[Expression]Expression, len(node.Pairs))
+		for k, v := range node.Pairs {
+			pairs[cloneExpression(k)] = cloneExpression(v)
+		}
+		return &HashLiteral{Token: node.Token, Pairs: pairs}
+	}
+	return node
+}
+
+func cloneExpression(exp Expression) Expression {
+	if exp == nil {
+		return nil
+	}
+	return Clone(exp).(Expression)
+}
+
+func cloneExpressions(exps []Expression) []Expression {
+	if exps == nil {
+		return nil
+	}
+	clones := make([]Expression, len(exps))
+	for i, exp := range exps {
+		clones[i] = cloneExpression(exp)
+	}
+	return clones
+}
+
+func cloneStatements(stmts []Statement) []Statement {
+	if stmts == nil {
+		return nil
+	}
+	clones := make([]Statement, len(stmts))
+	for i, stmt := range stmts {
+		if stmt != nil {
+			clones[i] = Clone(stmt).(Statement)
+		}
+	}
+	return clones
+}
+
+func cloneBlock(block *BlockStatement) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	return &BlockStatement{Token: block.Token, Statements: cloneStatements(block.Statements)}
+}
+
+func cloneIdentifier(ident *Identifier) *Identifier {
+	if ident == nil {
+		return nil
+	}
+	return &Identifier{Token: ident.Token, Value: ident.Value, Unquote: cloneExpression(ident.Unquote)}
+}
+
+func cloneIdentifiers(idents []*Identifier) []*Identifier {
+	if idents == nil {
+		return nil
+	}
+	clones := make([]*Identifier, len(idents))
+	for i, ident := range idents {
+		clones[i] = cloneIdentifier(ident)
+	}
+	return clones
+}
@@ -0,0 +1,63 @@
+package ast
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	original := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Name: &Identifier{Value: "f"},
+				Value: &FunctionLiteral{
+					Parameters: []*Identifier{{Value: "x"}},
+					Body: &BlockStatement{
+						Statements: []Statement{
+							&ExpressionStatement{Expression: &InfixExpression{
+								Left:     &Identifier{Value: "x"},
+								Operator: "+",
+								Right:    &IntegerLiteral{Value: 1},
+							}},
+						},
+					},
+				},
+				Doc: "doc",
+			},
+			&ExpressionStatement{Expression: &CallExpression{
+				Function:  &Identifier{Value: "f"},
+				Arguments: []Expression{&ArrayLiteral{Elements: []Expression{&IntegerLiteral{Value: 2}}}},
+			}},
+		},
+	}
+
+	clone := Clone(original)
+	if !reflect.DeepEqual(clone, original) {
+		t.Fatalf("clone not equal. got=%#v, want=%#v", clone, original)
+	}
+
+	// 修改拷贝不影响原AST
+	Modify(clone, func(node Node) Node {
+		if integer, ok := node.(*IntegerLiteral); ok {
+			integer.Value = 10
+		}
+		return node
+	})
+	if got := integerValues(original); !reflect.DeepEqual(got, []int64{1, 2}) {
+		t.Errorf("original was modified. got=%v", got)
+	}
+	if got := integerValues(clone); !reflect.DeepEqual(got, []int64{10, 10}) {
+		t.Errorf("clone was not modified. got=%v", got)
+	}
+}
+
+func integerValues(node Node) []int64 {
+	values := []int64{}
+	Modify(node, func(node Node) Node {
+		if integer, ok := node.(*IntegerLiteral); ok {
+			values = append(values, integer.Value)
+		}
+		return node
+	})
+	return values
+}
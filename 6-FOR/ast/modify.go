@@ -2,61 +2,126 @@ package ast
 
 type ModifierFunc func(Node) Node
 
+// 深度优先遍历AST,先修改子节点,再对节点本身调用modifier
+// modifier返回的节点类型不合适时(如语句位置返回了非语句),保留原节点
 func Modify(node Node, modifier ModifierFunc) Node {
 	switch node := node.(type) {
 	case *Program:
 		for i, statement := range node.Statements {
-			n, ok := Modify(statement, modifier).(Statement)
-			node.Statements[i] = n
-			if !ok {
-				panic("Program parse faid")
-			}
+			node.Statements[i] = modifyStatement(statement, modifier)
 		}
 	case *ExpressionStatement:
-		n, ok := Modify(node.Expression, modifier).(Expression)
-		node.Expression = n
-		if !ok {
-			panic("ExpressionStatement parse faid")
-		}
+		node.Expression = modifyExpression(node.Expression, modifier)
 	case *InfixExpression:
-		node.Left, _ = Modify(node.Left, modifier).(Expression)
-		node.Right, _ = Modify(node.Right, modifier).(Expression)
+		node.Left = modifyExpression(node.Left, modifier)
+		node.Right = modifyExpression(node.Right, modifier)
 	case *PrefixExpression:
-		node.Right, _ = Modify(node.Right, modifier).(Expression)
+		node.Right = modifyExpression(node.Right, modifier)
+	case *AssignExpression:
+		node.Target = modifyExpression(node.Target, modifier)
+		node.Value = modifyExpression(node.Value, modifier)
 	case *IndexExpression:
-		node.Left, _ = Modify(node.Left, modifier).(Expression)
-		node.Index, _ = Modify(node.Index, modifier).(Expression)
+		node.Left = modifyExpression(node.Left, modifier)
+		node.Index = modifyExpression(node.Index, modifier)
 	case *IfExpression:
-		node.Condition, _ = Modify(node.Condition, modifier).(Expression)
-		node.Consequence, _ = Modify(node.Consequence, modifier).(*BlockStatement)
-		if node.Alternative != nil {
-			node.Alternative, _ = Modify(node.Alternative, modifier).(*BlockStatement)
+		node.Condition = modifyExpression(node.Condition, modifier)
+		node.Consequence = modifyBlock(node.Consequence, modifier)
+		node.Alternative = modifyBlock(node.Alternative, modifier)
+	case *MatchExpression:
+		node.Subject = modifyExpression(node.Subject, modifier)
+		for _, arm := range node.Arms {
+			arm.Pattern = modifyExpression(arm.Pattern, modifier)
+			arm.Guard = modifyExpression(arm.Guard, modifier)
+			arm.Body = modifyBlock(arm.Body, modifier)
 		}
+	case *ForExpression:
+		node.Condition = modifyExpression(node.Condition, modifier)
+		node.Body = modifyBlock(node.Body, modifier)
+	case *ForRangeExpression:
+		node.Key = modifyIdentifier(node.Key, modifier)
+		node.Value = modifyIdentifier(node.Value, modifier)
+		node.Iterable = modifyExpression(node.Iterable, modifier)
+		node.Body = modifyBlock(node.Body, modifier)
 	case *BlockStatement:
-		for i, _ := range node.Statements {
-			node.Statements[i], _ = Modify(node.Statements[i], modifier).(Statement)
+		for i, statement := range node.Statements {
+			node.Statements[i] = modifyStatement(statement, modifier)
 		}
 	case *ReturnStatement:
-		node.ReturnValue, _ = Modify(node.ReturnValue, modifier).(Expression)
+		node.ReturnValue = modifyExpression(node.ReturnValue, modifier)
 	case *LetStatement:
-		node.Value, _ = Modify(node.Value, modifier).(Expression)
+		node.Name = modifyIdentifier(node.Name, modifier)
+		node.Value = modifyExpression(node.Value, modifier)
 	case *FunctionLiteral:
-		for i, _ := range node.Parameters {
-			node.Parameters[i] = Modify(node.Parameters[i], modifier).(*Identifier)
+		for i, param := range node.Parameters {
+			node.Parameters[i] = modifyIdentifier(param, modifier)
+		}
+		node.Body = modifyBlock(node.Body, modifier)
+	case *MacroLiteral:
+		node.Body = modifyBlock(node.Body, modifier)
+	case *CallExpression:
+		node.Function = modifyExpression(node.Function, modifier)
+		for i, arg := range node.Arguments {
+			node.Arguments[i] = modifyExpression(arg, modifier)
+		}
+	case *TemplateLiteral:
+		for i, part := range node.Parts {
+			node.Parts[i] = modifyExpression(part, modifier)
 		}
-		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
 	case *ArrayLiteral:
-		for i, _ := range node.Elements {
-			node.Elements[i], _ = Modify(node.Elements[i], modifier).(Expression)
+		for i, el := range node.Elements {
+			node.Elements[i] = modifyExpression(el, modifier)
 		}
 	case *HashLiteral:
 		newPairs := make(map[Expression]Expression)
 		for k, v := range node.Pairs {
-			newk, _ := Modify(k, modifier).(Expression)
-			newv, _ := Modify(v, modifier).(Expression)
-			newPairs[newk] = newv
+			newPairs[modifyExpression(k, modifier)] = modifyExpression(v, modifier)
 		}
 		node.Pairs = newPairs
+	case *MemberExpression:
+		// 成员名不是变量,不修改
+		node.Object = modifyExpression(node.Object, modifier)
+	case *UseExpression:
+		// 只有文件名和导入的名字,没有子节点
 	}
 	return modifier(node)
 }
+
+func modifyExpression(exp Expression, modifier ModifierFunc) Expression {
+	if exp == nil {
+		return nil
+	}
+	if modified, ok := Modify(exp, modifier).(Expression); ok {
+		return modified
+	}
+	return exp
+}
+
+func modifyStatement(stmt Statement, modifier ModifierFunc) Statement {
+	if stmt == nil {
+		return nil
+	}
+	if modified, ok := Modify(stmt, modifier).(Statement); ok {
+		return modified
+	}
+	return stmt
+}
+
+func modifyIdentifier(ident *Identifier, modifier ModifierFunc) *Identifier {
+	if ident == nil {
+		return nil
+	}
+	if modified, ok := Modify(ident, modifier).(*Identifier); ok {
+		return modified
+	}
+	return ident
+}
+
+func modifyBlock(block *BlockStatement, modifier ModifierFunc) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	if modified, ok := Modify(block, modifier).(*BlockStatement); ok {
+		return modified
+	}
+	return block
+}
@@ -115,6 +115,76 @@ func TestModify(t *testing.T) {
 			&ArrayLiteral{Elements: []Expression{one(), one()}},
 			&ArrayLiteral{Elements: []Expression{two(), two()}},
 		},
+		{
+			&CallExpression{Function: one(), Arguments: []Expression{one(), one()}},
+			&CallExpression{Function: two(), Arguments: []Expression{two(), two()}},
+		},
+		{
+			&AssignExpression{Target: one(), Operator: "=", Value: one()},
+			&AssignExpression{Target: two(), Operator: "=", Value: two()},
+		},
+		{
+			&TemplateLiteral{Parts: []Expression{one()}},
+			&TemplateLiteral{Parts: []Expression{two()}},
+		},
+		{
+			&ForExpression{
+				Condition: one(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&ForExpression{
+				Condition: two(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&ForRangeExpression{
+				Iterable: one(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&ForRangeExpression{
+				Iterable: two(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&MatchExpression{
+				Subject: one(),
+				Arms: []*MatchArm{{
+					Pattern: one(),
+					Guard:   one(),
+					Body:    &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+				}},
+			},
+			&MatchExpression{
+				Subject: two(),
+				Arms: []*MatchArm{{
+					Pattern: two(),
+					Guard:   two(),
+					Body:    &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+				}},
+			},
+		},
+		{
+			&UseExpression{FileName: "a.mal"},
+			&UseExpression{FileName: "a.mal"},
+		},
 	}
 
 	for _, tt := range ts {
@@ -145,3 +215,22 @@ func TestModify(t *testing.T) {
 		}
 	}
 }
+
+func TestModifyKeepsNodeOfWrongType(t *testing.T) {
+	// 语句位置被替换成表达式时保留原语句
+	toExpression := func(node Node) Node {
+		if _, ok := node.(*LetStatement); ok {
+			return &IntegerLiteral{Value: 1}
+		}
+		return node
+	}
+
+	let := &LetStatement{Name: &Identifier{Value: "x"}, Value: &IntegerLiteral{Value: 2}}
+	program := &Program{Statements: []Statement{let}}
+
+	Modify(program, toExpression)
+
+	if program.Statements[0] != let {
+		t.Errorf("statement was replaced. got=%#v", program.Statements[0])
+	}
+}
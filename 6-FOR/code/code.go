@@ -5,6 +5,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
+	"malang/token"
+	"sort"
 )
 
 // 操作码定义
@@ -20,16 +23,56 @@ const (
 	OpEqual
 	OpNotEqual
 	OpGreaterThan
-	OpMinus         // - 负号
-	OpBang          // !
-	OpJumpNotTruthy // 有条件跳转
-	OpJump          // 无条件跳转
-	OpNull          // 将Null压栈
-	OpGetGlobal     // 从全局存储中取值
-	OpSetGlobal     // 向全局存储中存值
-	OpArray         // 构建数组
-	OpHash          // 构建哈希
-	OpIndex         // 索引运算
+	OpMinus          // - 负号
+	OpBang           // !
+	OpJumpNotTruthy  // 有条件跳转
+	OpJump           // 无条件跳转
+	OpNull           // 将Null压栈
+	OpGetGlobal      // 从全局存储中取值
+	OpSetGlobal      // 向全局存储中存值
+	OpArray          // 构建数组
+	OpHash           // 构建哈希
+	OpIndex          // 索引运算
+	OpGreaterEqual   // >=
+	OpMod            // %
+	OpPow            // **
+	OpBitAnd         // &
+	OpBitOr          // |
+	OpBitXor         // ^
+	OpShl            // <<
+	OpShr            // >>
+	OpTemplate       // 拼接插值字符串的各个片段,操作数为片段数
+	OpLessThan       // <
+	OpLessEqual      // <=
+	OpCall           // 调用函数,操作数为参数个数
+	OpReturnValue    // 返回栈顶的值
+	OpReturn         // 没有返回值,返回null
+	OpGetLocal       // 从局部绑定中取值
+	OpSetLocal       // 向局部绑定中存值
+	OpGetBuiltin     // 取内置函数
+	OpClosure        // 构建闭包,操作数为函数常量的下标和自由变量的个数
+	OpGetFree        // 取闭包捕获的自由变量
+	OpCurrentClosure // 取当前执行的闭包,用于递归调用
+	OpSetIndex       // 索引赋值,操作数为复合赋值对应的运算指令,普通赋值为0
+	OpIter           // 把被遍历的对象换成迭代器,操作数为循环变量的个数
+	OpIterNext       // 迭代结束时跳转到操作数的位置,否则压入下标(键)和元素(值)
+	OpGetLocalCell   // 读取局部绑定中cell的值
+	OpSetLocalCell   // 写入局部绑定中的cell,没有cell时创建
+	OpGetFreeCell    // 读取捕获的cell的值
+	OpSetFreeCell    // 写入捕获的cell
+	OpClearLocals    // 清空一段局部绑定,操作数为起始下标和个数
+	OpMatchArray     // 弹出值,是长度等于操作数的数组时压入true
+	OpMatchHash      // 弹出值,是哈希表时压入true
+	OpMatchKey       // 弹出键和哈希表,哈希表中有这个键时压入true
+	OpMatchLiteral   // 弹出值和字面量模式,两者相等时压入true
+	OpGetModule      // 模块已经运行时压入保存在全局变量中的模块并跳转,操作数为全局变量下标和跳转位置
+	OpModule         // 构建模块,操作数为模块名常量的下标和栈上名字与值的个数
+	OpMember         // 取模块的成员,操作数为成员名常量的下标
+	OpBreakValue     // 把break作为值压栈,用于不在语句位置的break
+	OpContinueValue  // 把continue作为值压栈
+	OpGetGlobalCell  // 读取全局绑定中cell的值,用于顶层块作用域中被闭包捕获的变量
+	OpSetGlobalCell  // 写入全局绑定中的cell,没有cell时创建
+	OpClearGlobals   // 清空一段全局绑定中的cell,操作数为起始下标和个数
 )
 
 type Instructions []byte
@@ -66,6 +109,47 @@ var definitions = map[Opcode]*Definition{
 	OpArray:         {"OpArray", []int{2}},
 	OpHash:          {"OpHash", []int{2}},
 	OpIndex:         {"OpIndex", []int{}},
+	OpGreaterEqual:  {"OpGreaterEqual", []int{}},
+	OpMod:           {"OpMod", []int{}},
+	OpPow:           {"OpPow", []int{}},
+	OpBitAnd:        {"OpBitAnd", []int{}},
+	OpBitOr:         {"OpBitOr", []int{}},
+	OpBitXor:        {"OpBitXor", []int{}},
+	OpShl:           {"OpShl", []int{}},
+	OpShr:           {"OpShr", []int{}},
+	OpTemplate:      {"OpTemplate", []int{2}},
+	OpLessThan:      {"OpLessThan", []int{}},
+	OpLessEqual:     {"OpLessEqual", []int{}},
+	// 参数个数、局部绑定、内置函数和自由变量的下标只占一个字节
+	OpCall:           {"OpCall", []int{1}},
+	OpReturnValue:    {"OpReturnValue", []int{}},
+	OpReturn:         {"OpReturn", []int{}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpClosure:        {"OpClosure", []int{2, 1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpSetIndex:       {"OpSetIndex", []int{1}},
+	OpIter:           {"OpIter", []int{1}},
+	OpIterNext:       {"OpIterNext", []int{2}},
+	OpGetLocalCell:   {"OpGetLocalCell", []int{1}},
+	OpSetLocalCell:   {"OpSetLocalCell", []int{1}},
+	OpGetFreeCell:    {"OpGetFreeCell", []int{1}},
+	OpSetFreeCell:    {"OpSetFreeCell", []int{1}},
+	OpClearLocals:    {"OpClearLocals", []int{1, 1}},
+	OpMatchArray:     {"OpMatchArray", []int{2}},
+	OpMatchHash:      {"OpMatchHash", []int{}},
+	OpMatchKey:       {"OpMatchKey", []int{}},
+	OpMatchLiteral:   {"OpMatchLiteral", []int{}},
+	OpGetModule:      {"OpGetModule", []int{2, 2}},
+	OpModule:         {"OpModule", []int{2, 2}},
+	OpMember:         {"OpMember", []int{2}},
+	OpBreakValue:     {"OpBreakValue", []int{}},
+	OpContinueValue:  {"OpContinueValue", []int{}},
+	OpGetGlobalCell:  {"OpGetGlobalCell", []int{2}},
+	OpSetGlobalCell:  {"OpSetGlobalCell", []int{2}},
+	OpClearGlobals:   {"OpClearGlobals", []int{2, 2}},
 }
 
 // 查看操作码定义
@@ -78,6 +162,20 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
+// 指令集的指纹,操作码的编号、名字或操作数宽度变化时都会改变
+// 编译后的程序文件记录指纹,避免旧文件中的操作码被解释成别的指令
+func Fingerprint() uint32 {
+	var buf bytes.Buffer
+	for op := 0; op < 256; op++ {
+		def, ok := definitions[Opcode(op)]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&buf, "%d %s %v\n", op, def.Name, def.OperandWidths)
+	}
+	return crc32.ChecksumIEEE(buf.Bytes())
+}
+
 // 快速构建单字节码指令
 func Make(op Opcode, operands ...int) []byte {
 	// 从已定义的操作码中寻找
@@ -106,6 +204,8 @@ func Make(op Opcode, operands ...int) []byte {
 		case 2:
 			// 操作数大端编码为uint16到instruction
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
 		}
 		// 向后移动，继续遍历操作数
 		offset += width
@@ -114,6 +214,21 @@ func Make(op Opcode, operands ...int) []byte {
 	return instruction
 }
 
+// 检查操作数能否放进操作码定义的宽度,Make会截断放不下的操作数
+func CheckOperands(op Opcode, operands ...int) error {
+	def, ok := definitions[op]
+	if !ok {
+		return fmt.Errorf("opcode %d undefined", op)
+	}
+	for i, o := range operands {
+		max := 1<<(8*def.OperandWidths[i]) - 1
+		if o < 0 || o > max {
+			return fmt.Errorf("operand %d of %s exceeds %d", o, def.Name, max)
+		}
+	}
+	return nil
+}
+
 // 更好地打印字节码指令
 func (ins Instructions) String() string {
 	var out bytes.Buffer
@@ -123,6 +238,7 @@ func (ins Instructions) String() string {
 		def, err := Lookup(ins[i])
 		if err != nil {
 			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
 			continue
 		}
 
@@ -150,6 +266,8 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 		return def.Name
 	case 1:
 		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
 	}
 
 	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
@@ -165,6 +283,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 		switch width {
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
 		}
 
 		offset += width
@@ -176,3 +296,25 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+// 调试信息: 从Offset开始的指令对应源码位置Pos
+type LineEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+// 指令的源码位置表,按Offset递增排列
+type LineTable []LineEntry
+
+// 查找偏移处的指令对应的源码位置,没有调试信息时返回无效的位置
+func (t LineTable) Lookup(offset int) token.Position {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return t[i-1].Pos
+}
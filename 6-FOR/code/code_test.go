@@ -1,6 +1,9 @@
 package code
 
-import "testing"
+import (
+	"malang/token"
+	"testing"
+)
 
 func TestMake(t *testing.T) {
 	ts := []struct {
@@ -10,6 +13,8 @@ func TestMake(t *testing.T) {
 	}{
 		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
 	}
 
 	for _, tt := range ts {
@@ -27,6 +32,33 @@ func TestMake(t *testing.T) {
 	}
 }
 
+func TestCheckOperands(t *testing.T) {
+	ts := []struct {
+		op       Opcode
+		operands []int
+		expected string
+	}{
+		{OpConstant, []int{65535}, ""},
+		{OpConstant, []int{65536}, "operand 65536 of OpConstant exceeds 65535"},
+		{OpJump, []int{70000}, "operand 70000 of OpJump exceeds 65535"},
+		{OpCall, []int{255}, ""},
+		{OpCall, []int{256}, "operand 256 of OpCall exceeds 255"},
+		{OpClosure, []int{0, 256}, "operand 256 of OpClosure exceeds 255"},
+		{OpGetLocal, []int{-1}, "operand -1 of OpGetLocal exceeds 255"},
+	}
+
+	for _, tt := range ts {
+		err := CheckOperands(tt.op, tt.operands...)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.expected {
+			t.Errorf("wrong error for %v. want=%q, got=%q", tt.operands, tt.expected, got)
+		}
+	}
+}
+
 func TestInstructionsString(t *testing.T) {
 	// 	instructions := []Instructions{
 	// 		Make(OpConstant, 1),
@@ -41,13 +73,17 @@ func TestInstructionsString(t *testing.T) {
 
 	instructions := []Instructions{
 		Make(OpAdd),
+		Make(OpGetLocal, 1),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
 	}
 
 	expected := `0000 OpAdd
-0001 OpConstant 2
-0004 OpConstant 65535
+0001 OpGetLocal 1
+0003 OpConstant 2
+0006 OpConstant 65535
+0009 OpClosure 65535 255
 `
 
 	concatted := Instructions{}
@@ -67,6 +103,10 @@ func TestReadOperands(t *testing.T) {
 		bytesRead int
 	}{
 		{OpConstant, []int{65535}, 2},
+		{OpTemplate, []int{3}, 2},
+		{OpGreaterEqual, []int{}, 0},
+		{OpGetLocal, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
 	}
 	for _, tt := range ts {
 		instruction := Make(tt.op, tt.operands...)
@@ -89,3 +129,63 @@ func TestReadOperands(t *testing.T) {
 	}
 }
 
+func TestInstructionsStringUnknownOpcode(t *testing.T) {
+	ins := Instructions{255, byte(OpPop)}
+
+	expected := "ERROR: opcode 255 undefined\n0001 OpPop\n"
+	if ins.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", expected, ins.String())
+	}
+}
+
+func TestLineTableLookup(t *testing.T) {
+	table := LineTable{
+		{Offset: 0, Pos: token.Position{Line: 1, Column: 1}},
+		{Offset: 3, Pos: token.Position{Line: 1, Column: 5}},
+		{Offset: 7, Pos: token.Position{Line: 2, Column: 1}},
+	}
+	ts := []struct {
+		offset   int
+		expected token.Position
+	}{
+		{0, token.Position{Line: 1, Column: 1}},
+		{2, token.Position{Line: 1, Column: 1}},
+		{3, token.Position{Line: 1, Column: 5}},
+		{6, token.Position{Line: 1, Column: 5}},
+		{100, token.Position{Line: 2, Column: 1}},
+	}
+	for _, tt := range ts {
+		if pos := table.Lookup(tt.offset); pos != tt.expected {
+			t.Errorf("wrong position at %d. want=%s, got=%s", tt.offset, tt.expected, pos)
+		}
+	}
+	if pos := (LineTable{}).Lookup(0); pos.IsValid() {
+		t.Errorf("empty table should return invalid position. got=%s", pos)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	original := Fingerprint()
+	if Fingerprint() != original {
+		t.Fatalf("fingerprint is not stable")
+	}
+
+	// 操作数宽度、名字变化或新增操作码都会改变指纹
+	changes := []func(){
+		func() { definitions[OpPop] = &Definition{"OpPop", []int{1}} },
+		func() { definitions[OpPop] = &Definition{"OpDrop", []int{}} },
+		func() { definitions[OpClearGlobals+1] = &Definition{"OpNew", []int{}} },
+	}
+	for i, change := range changes {
+		saved := definitions[OpPop]
+		change()
+		if Fingerprint() == original {
+			t.Errorf("change %d: fingerprint did not change", i)
+		}
+		definitions[OpPop] = saved
+		delete(definitions, OpClearGlobals+1)
+	}
+	if Fingerprint() != original {
+		t.Fatalf("definitions were not restored")
+	}
+}
@@ -0,0 +1,1338 @@
+// compiler/compiler.go
+package compiler
+
+import (
+	"fmt"
+	"malang/ast"
+	"malang/code"
+	"malang/evaluator"
+	"malang/object"
+	"malang/token"
+	"malang/util"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 编译器,把AST编译为字节码指令和常量池
+type Compiler struct {
+	constants     []object.Object  // 常量池
+	constantIndex map[constant]int // 整数、浮点数和字符串常量在常量池中的下标,相同的常量只保存一次
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope // 每个正在编译的函数一个作用域,scopes[0]是主程序
+	scopeIndex int
+
+	position token.Position // 正在编译的节点的源码位置
+
+	// 正在编译的节点是否在语句的位置,即经过块和if、match的分支到达循环体
+	// 和解释器一样,只有在语句位置的break和continue才跳转,其他位置的break和continue是一个值
+	atStatement bool
+
+	loading []loadingModule // 正在编译的模块链(导入顺序),用于检测循环导入
+
+	err error // 放不下的操作数,发出指令时记录,由Compile返回
+}
+
+// 可以合并的常量
+type constant struct {
+	kind  object.ObjectType
+	value interface{}
+}
+
+type loadingModule struct {
+	path string // 报错时显示的路径
+	key  string // 绝对路径
+}
+
+// 编译作用域,保存一个函数体生成的指令
+type CompilationScope struct {
+	instructions        code.Instructions  // 生成的字节码
+	lastInstruction     EmittedInstruction // 最后一条发出的指令
+	previousInstruction EmittedInstruction // 倒数第二条发出的指令
+	lines               code.LineTable     // 指令对应的源码位置
+
+	loops []*loop // 正在编译的循环,最内层的在最后
+}
+
+// 循环的跳转信息
+type loop struct {
+	start      int   // 循环开始的位置,continue跳到这里
+	breakJumps []int // break的跳转指令位置,循环结束后回填
+}
+
+// 已发出的指令
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// 编译结果,交给虚拟机执行
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	Lines        code.LineTable // 主程序的调试信息
+	GlobalNames  []string       // 全局变量的名字,调试信息
+}
+
+// 中缀运算符对应的操作码
+var infixOperators = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"**": code.OpPow,
+	"&":  code.OpBitAnd,
+	"|":  code.OpBitOr,
+	"^":  code.OpBitXor,
+	"<<": code.OpShl,
+	">>": code.OpShr,
+	">":  code.OpGreaterThan,
+	">=": code.OpGreaterEqual,
+	"<":  code.OpLessThan,
+	"<=": code.OpLessEqual,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+}
+
+func New() *Compiler {
+	return NewWithState(NewSymbolTableWithBuiltins(), []object.Object{})
+}
+
+// 使用已有的符号表和常量池创建编译器,控制台用它在多行输入之间保留全局变量
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	mainScope := CompilationScope{
+		instructions: code.Instructions{},
+	}
+
+	c := &Compiler{
+		constants:     constants,
+		constantIndex: map[constant]int{},
+		symbolTable:   s,
+		scopes:        []CompilationScope{mainScope},
+		scopeIndex:    0,
+	}
+	for i, obj := range constants {
+		if key, ok := constantKey(obj); ok {
+			if _, ok := c.constantIndex[key]; !ok {
+				c.constantIndex[key] = i
+			}
+		}
+	}
+	return c
+}
+
+// 创建定义了所有内置函数的全局符号表
+func NewSymbolTableWithBuiltins() *SymbolTable {
+	symbolTable := NewSymbolTable()
+	for i, name := range evaluator.BuiltinNames {
+		symbolTable.DefineBuiltin(i, name)
+	}
+	return symbolTable
+}
+
+// 编译节点,遇到不支持的节点时返回错误
+func (c *Compiler) Compile(node ast.Node) (err error) {
+	defer func() {
+		if err == nil && c.err != nil {
+			err, c.err = c.err, nil
+		}
+	}()
+	// 之后发出的指令对应到最内层正在编译的节点,运行时报错使用这个位置
+	if node != nil && node.Pos().IsValid() {
+		outer := c.position
+		c.position = node.Pos()
+		defer func() { c.position = outer }()
+	}
+	atStatement := c.atStatement
+	switch node.(type) {
+	case *ast.BlockStatement, *ast.ExpressionStatement:
+	default:
+		c.atStatement = false
+	}
+	defer func() { c.atStatement = atStatement }()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		// 顶层块作用域中被内层函数引用的全局变量保存在cell中
+		if c.symbolTable.Outer == nil {
+			c.symbolTable.Captured = capturedNames(&ast.BlockStatement{Statements: node.Statements})
+		}
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+	case *ast.ExpressionStatement:
+		if node.Expression == nil {
+			return nil
+		}
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		// 表达式语句的结果不再使用,弹出栈
+		c.emit(code.OpPop)
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+	case *ast.LetStatement:
+		// 先编译值再定义名字,值中的同名变量指向外层的绑定;函数通过自身的名字递归
+		fn, ok := node.Value.(*ast.FunctionLiteral)
+		if ok && assignedNames(fn.Body)[node.Name.Value] {
+			return c.compileSelfAssigningFunction(node, fn)
+		}
+		if ok {
+			if err := c.compileFunction(fn, node.Name.Value); err != nil {
+				return err
+			}
+		} else if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		symbol, err := c.define(node, node.Name.Value)
+		if err != nil {
+			return err
+		}
+		c.storeSymbol(symbol)
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			return errorAt(node, "undefined variable %s", node.Value)
+		}
+		c.loadSymbol(symbol)
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return errorAt(node, "unknown operator %s", node.Operator)
+		}
+	case *ast.InfixExpression:
+		return c.compileInfixExpression(node)
+	case *ast.IfExpression:
+		return c.compileIfExpression(node, atStatement)
+	case *ast.MatchExpression:
+		return c.compileMatchExpression(node, atStatement)
+	case *ast.UseExpression:
+		return c.compileUseExpression(node)
+	case *ast.MemberExpression:
+		if err := c.Compile(node.Object); err != nil {
+			return err
+		}
+		c.emit(code.OpMember, c.addConstant(&object.String{Value: node.Member.Value}))
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+	case *ast.TemplateLiteral:
+		for _, part := range node.Parts {
+			if err := c.Compile(part); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpTemplate, len(node.Parts))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		for _, k := range sortedKeys(node) {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
+	case *ast.ForExpression:
+		return c.compileForExpression(node)
+	case *ast.ForRangeExpression:
+		return c.compileForRangeExpression(node)
+	case *ast.BreakExpression:
+		loop := c.currentLoop()
+		if loop == nil {
+			return errorAt(node, "break outside of loop")
+		}
+		if !atStatement {
+			c.emit(code.OpBreakValue)
+			return nil
+		}
+		// 循环结束的位置还不知道,先占位
+		loop.breakJumps = append(loop.breakJumps, c.emit(code.OpJump, 9999))
+	case *ast.ContinueExpression:
+		loop := c.currentLoop()
+		if loop == nil {
+			return errorAt(node, "continue outside of loop")
+		}
+		if !atStatement {
+			c.emit(code.OpContinueValue)
+			return nil
+		}
+		c.emit(code.OpJump, loop.start)
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node, "")
+	case *ast.CallExpression:
+		// quote和宏展开需要在运行时拿到语法树,只有解释器支持
+		switch name := node.Function.TokenLiteral(); name {
+		case "quote", "macroexpand", "macroexpand1":
+			return errorAt(node, "%s is not supported by the VM", name)
+		}
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		for _, arg := range node.Arguments {
+			if err := c.Compile(arg); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+	default:
+		return errorAt(node, "cannot compile %T", node)
+	}
+	return nil
+}
+
+// 编译中缀表达式
+func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
+	if node.Operator == "&&" || node.Operator == "||" {
+		return c.compileLogicalExpression(node)
+	}
+
+	op, ok := infixOperators[node.Operator]
+	if !ok {
+		return errorAt(node, "unknown operator %s", node.Operator)
+	}
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	c.emit(op)
+	return nil
+}
+
+// 编译 && 和 ||,短路求值,结果为布尔值
+//
+//	a && b: a, JumpNotTruthy F, b, Bang, Bang, Jump END, F: False, END:
+//	a || b: a, JumpNotTruthy R, True, Jump END, R: b, Bang, Bang, END:
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if node.Operator == "&&" {
+		if err := c.compileTruthy(node.Right); err != nil {
+			return err
+		}
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.emit(code.OpFalse)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	c.emit(code.OpTrue)
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	if err := c.compileTruthy(node.Right); err != nil {
+		return err
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// 编译表达式并转换为布尔值(!!x)
+func (c *Compiler) compileTruthy(node ast.Expression) error {
+	if err := c.Compile(node); err != nil {
+		return err
+	}
+	c.emit(code.OpBang)
+	c.emit(code.OpBang)
+	return nil
+}
+
+// 编译if表达式,先发出占位的跳转指令,知道目标位置后再回填
+// atStatement表示if是否在语句的位置,分支中的语句和if本身一样
+func (c *Compiler) compileIfExpression(node *ast.IfExpression, atStatement bool) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	// 条件不成立时跳到else分支
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	c.atStatement = atStatement
+
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+
+	// 执行完consequence后跳过else分支
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBlockValue(node.Alternative); err != nil {
+		return err
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// 编译match表达式,被匹配的值保存在只在match中可见的隐藏变量中,依次检查每个分支
+//
+//	值, 保存, 分支: 模式(JumpNotTruthy NEXT), 守卫(JumpNotTruthy NEXT), 分支体, Jump END, NEXT: 下一个分支 ... Null, END:
+func (c *Compiler) compileMatchExpression(node *ast.MatchExpression, atStatement bool) error {
+	if err := c.Compile(node.Subject); err != nil {
+		return err
+	}
+
+	// 和range循环一样,每次执行match时分支中绑定的变量都是新的
+	clearPos, blockStart := c.enterBlock()
+	err := c.compileMatchArms(node, atStatement)
+	c.leaveBlock(clearPos, blockStart)
+	return err
+}
+
+func (c *Compiler) compileMatchArms(node *ast.MatchExpression, atStatement bool) error {
+	// 隐藏变量的名字不是合法的标识符,不会和程序中的名字冲突
+	subject, err := c.define(node, "$match")
+	if err != nil {
+		return err
+	}
+	c.storeSymbol(subject)
+	loadSubject := func() error {
+		c.loadSymbol(subject)
+		return nil
+	}
+
+	endJumps := []int{}
+	for _, arm := range node.Arms {
+		// 模式中绑定的变量只在当前分支内可见
+		c.symbolTable.EnterBlock()
+		failJumps, err := c.compileMatchArm(arm, loadSubject, atStatement)
+		c.symbolTable.LeaveBlock()
+		if err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+		for _, pos := range failJumps {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+	}
+
+	// 没有匹配的分支
+	c.emit(code.OpNull)
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// 编译一个分支,分支体的值留在栈上,返回不匹配时的跳转指令位置
+// 分支体中的语句和match本身一样在语句的位置,模式和守卫不在
+func (c *Compiler) compileMatchArm(arm *ast.MatchArm, loadSubject func() error, atStatement bool) ([]int, error) {
+	failJumps, err := c.compilePattern(arm.Pattern, loadSubject)
+	if err != nil {
+		return nil, err
+	}
+	if arm.Guard != nil {
+		if err := c.Compile(arm.Guard); err != nil {
+			return nil, err
+		}
+		failJumps = append(failJumps, c.emit(code.OpJumpNotTruthy, 9999))
+	}
+	c.atStatement = atStatement
+	err = c.compileBlockValue(arm.Body)
+	c.atStatement = false
+	if err != nil {
+		return nil, err
+	}
+	return failJumps, nil
+}
+
+// 编译模式检查,load发出读取被检查的值的指令
+// 检查通过时绑定模式中的变量,返回检查失败时的跳转指令位置
+func (c *Compiler) compilePattern(pattern ast.Expression, load func() error) ([]int, error) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		// _ 匹配任意值,不绑定
+		if pattern.Value == "_" {
+			return nil, nil
+		}
+		if err := load(); err != nil {
+			return nil, err
+		}
+		symbol, err := c.define(pattern, pattern.Value)
+		if err != nil {
+			return nil, err
+		}
+		c.storeSymbol(symbol)
+		return nil, nil
+	case *ast.ArrayLiteral:
+		if err := load(); err != nil {
+			return nil, err
+		}
+		c.emit(code.OpMatchArray, len(pattern.Elements))
+		failJumps := []int{c.emit(code.OpJumpNotTruthy, 9999)}
+		for i, el := range pattern.Elements {
+			index := c.addConstant(&object.Integer{Value: int64(i)})
+			elementJumps, err := c.compilePattern(el, func() error {
+				if err := load(); err != nil {
+					return err
+				}
+				c.emit(code.OpConstant, index)
+				c.emit(code.OpIndex)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			failJumps = append(failJumps, elementJumps...)
+		}
+		return failJumps, nil
+	case *ast.HashLiteral:
+		if err := load(); err != nil {
+			return nil, err
+		}
+		c.emit(code.OpMatchHash)
+		failJumps := []int{c.emit(code.OpJumpNotTruthy, 9999)}
+		// 只要求模式中的键都存在,允许多余的键
+		for _, key := range sortedKeys(pattern) {
+			loadValue := func() error {
+				if err := load(); err != nil {
+					return err
+				}
+				if err := c.Compile(key); err != nil {
+					return err
+				}
+				c.emit(code.OpIndex)
+				return nil
+			}
+			if err := load(); err != nil {
+				return nil, err
+			}
+			if err := c.Compile(key); err != nil {
+				return nil, err
+			}
+			c.emit(code.OpMatchKey)
+			failJumps = append(failJumps, c.emit(code.OpJumpNotTruthy, 9999))
+
+			valueJumps, err := c.compilePattern(pattern.Pairs[key], loadValue)
+			if err != nil {
+				return nil, err
+			}
+			failJumps = append(failJumps, valueJumps...)
+		}
+		return failJumps, nil
+	default:
+		// 字面量
+		if err := c.Compile(pattern); err != nil {
+			return nil, err
+		}
+		if err := load(); err != nil {
+			return nil, err
+		}
+		c.emit(code.OpMatchLiteral)
+		return []int{c.emit(code.OpJumpNotTruthy, 9999)}, nil
+	}
+}
+
+// 编译作为表达式的块,块的值(最后一个表达式语句)留在栈上,没有值时为null
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// 编译赋值表达式,表达式的值是赋值后的值
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	// 复合赋值 x += 1 对应的运算指令
+	var op code.Opcode
+	if node.Operator != "=" {
+		op = infixOperators[strings.TrimSuffix(node.Operator, "=")]
+	}
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok || symbol.Scope == BuiltinScope {
+			return errorAt(node, "assignment to undeclared variable: %s", target.Value)
+		}
+		if op != 0 {
+			c.loadSymbol(symbol)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if op != 0 {
+			c.emit(op)
+		}
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex, int(op))
+	default:
+		return errorAt(node, "invalid assignment target: %s", node.Target)
+	}
+	return nil
+}
+
+// 编译for循环,循环的值为null
+//
+//	START: 条件, JumpNotTruthy END, 循环体, Jump START, END: Null
+func (c *Compiler) compileForExpression(node *ast.ForExpression) error {
+	start := len(c.currentInstructions())
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	c.enterLoop(start)
+	c.atStatement = true
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+
+	end := len(c.currentInstructions())
+	c.changeOperand(jumpNotTruthyPos, end)
+	c.leaveLoop(end)
+
+	c.emit(code.OpNull)
+	return nil
+}
+
+// 编译范围循环,迭代器在循环期间留在栈上,循环变量只在循环体中可见
+//
+//	对象, Iter, START: IterNext END, 设置值和下标, 循环体, Jump START, END: Pop, Null
+func (c *Compiler) compileForRangeExpression(node *ast.ForRangeExpression) error {
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+	numVars := 1
+	if node.Key != nil {
+		numVars = 2
+	}
+	c.emit(code.OpIter, numVars)
+
+	start := c.emit(code.OpIterNext, 9999)
+
+	// 每次迭代的变量都是新的
+	clearPos, blockStart := c.enterBlock()
+	// 栈顶是值,下面是下标
+	value := c.symbolTable.Define(node.Value.Value)
+	c.storeSymbol(value)
+	if node.Key != nil {
+		c.storeSymbol(c.symbolTable.Define(node.Key.Value))
+	} else {
+		c.emit(code.OpPop)
+	}
+
+	c.enterLoop(start)
+	c.atStatement = true
+	err := c.Compile(node.Body)
+	c.leaveBlock(clearPos, blockStart)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+
+	end := len(c.currentInstructions())
+	c.changeOperand(start, end)
+	c.leaveLoop(end)
+
+	// 弹出迭代器
+	c.emit(code.OpPop)
+	c.emit(code.OpNull)
+	return nil
+}
+
+// 进入块作用域,有被闭包捕获的变量时先清空上一次执行块时留在绑定中的cell,
+// 这次执行块时保存变量会创建新的cell。顶层的块中定义的是全局变量,清空全局绑定中的cell
+// 返回清空指令的位置(没有时为-1)和块中第一个绑定的下标,离开块时回填个数
+func (c *Compiler) enterBlock() (int, int) {
+	c.symbolTable.EnterBlock()
+	blockStart := c.symbolTable.numDefinitions
+	if len(c.symbolTable.Captured) == 0 {
+		return -1, blockStart
+	}
+	if c.symbolTable.Outer == nil {
+		return c.emit(code.OpClearGlobals, blockStart, 0), blockStart
+	}
+	return c.emit(code.OpClearLocals, blockStart, 0), blockStart
+}
+
+func (c *Compiler) leaveBlock(clearPos, blockStart int) {
+	if clearPos != -1 {
+		c.changeOperand(clearPos, blockStart, c.symbolTable.numDefinitions-blockStart)
+	}
+	c.symbolTable.LeaveBlock()
+}
+
+func (c *Compiler) enterLoop(start int) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, &loop{start: start})
+}
+
+// 离开循环,把break回填为跳到end
+func (c *Compiler) leaveLoop(end int) {
+	scope := &c.scopes[c.scopeIndex]
+	loop := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	for _, pos := range loop.breakJumps {
+		c.changeOperand(pos, end)
+	}
+}
+
+// 当前函数中最内层的循环,break和continue不能跨越函数
+func (c *Compiler) currentLoop() *loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// 编译use表达式,模块编译为一个返回模块的函数,第一次执行use时运行,之后的use得到保存在全局变量中的模块
+//
+//	GetModule M END, Closure F, Call 0, SetGlobal M, GetGlobal M, END: 绑定名字
+func (c *Compiler) compileUseExpression(node *ast.UseExpression) error {
+	module, err := c.compileModule(node)
+	if err != nil {
+		return err
+	}
+
+	// 模块留在栈上作为use表达式的值
+	c.loadModule(module)
+	switch {
+	case node.Names != nil:
+		for _, name := range node.Names {
+			// 宏在编译前已经展开
+			if macro, ok := module.macros.Get(name.Value); ok {
+				if _, ok := macro.(*object.Macro); ok {
+					continue
+				}
+			}
+			symbol, err := c.define(name, name.Value)
+			if err != nil {
+				return err
+			}
+			outer := c.position
+			c.position = name.Pos()
+			c.emit(code.OpGetGlobal, module.global)
+			c.emit(code.OpMember, c.addConstant(&object.String{Value: name.Value}))
+			c.position = outer
+			c.storeSymbol(symbol)
+		}
+	case node.Alias != nil:
+		if err := c.bindValue(node.Alias, node.Alias.Value); err != nil {
+			return err
+		}
+	default:
+		if err := c.bindValue(node, module.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 第一次执行时运行模块并保存到模块的全局变量,之后直接读取,模块压入栈中
+func (c *Compiler) loadModule(module *compiledModule) {
+	getPos := c.emit(code.OpGetModule, module.global, 9999)
+	c.emit(code.OpClosure, module.function, 0)
+	c.emit(code.OpCall, 0)
+	c.emit(code.OpSetGlobal, module.global)
+	c.emit(code.OpGetGlobal, module.global)
+	c.changeOperand(getPos, module.global, len(c.currentInstructions()))
+}
+
+// 把栈顶的值绑定到名字,值仍然留在栈上
+func (c *Compiler) bindValue(node ast.Node, name string) error {
+	symbol, err := c.define(node, name)
+	if err != nil {
+		return err
+	}
+	c.storeSymbol(symbol)
+	c.loadSymbol(symbol)
+	return nil
+}
+
+// 查找、解析并编译模块,每个模块只编译一次
+// 模块有自己的根符号表,只能使用内置函数和标准库
+func (c *Compiler) compileModule(node *ast.UseExpression) (*compiledModule, error) {
+	root := c.symbolTable.globals()
+	name, path, key := evaluator.StdModuleName, evaluator.StdModuleName, evaluator.StdModuleName
+	if node.FileName != evaluator.StdModuleName+util.MAL_EXT {
+		var err error
+		path, err = util.ResolveModule(node.FileName, node.Pos().File)
+		if err != nil {
+			return nil, errorAt(node, "%s", err)
+		}
+		key, err = filepath.Abs(path)
+		if err != nil {
+			return nil, errorAt(node, "%s", err)
+		}
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	if module, ok := root.modules[key]; ok {
+		return module, nil
+	}
+	for i, m := range c.loading {
+		if m.key == key {
+			chain := []string{}
+			for _, m := range c.loading[i:] {
+				chain = append(chain, m.path)
+			}
+			chain = append(chain, path)
+			return nil, errorAt(node, "import cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	program, err := loadModuleProgram(node, path)
+	if err != nil {
+		return nil, err
+	}
+	module := &compiledModule{name: name}
+	if path != evaluator.StdModuleName {
+		module.exports = evaluator.ModuleExports(program)
+	}
+	// 和解释器一样,模块中可以使用标准库的宏
+	macroEnv := object.NewEnvironment()
+	if path != evaluator.StdModuleName {
+		moduleEnv, err := evaluator.NewModuleEnvironment()
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Inspect())
+		}
+		macroEnv = object.NewEnclosedEnvironment(moduleEnv)
+	}
+	module.macros = &object.Module{Name: name, Env: macroEnv, Exports: module.exports}
+	evaluator.DefineMacros(program, module.macros.Env)
+	expanded, expandErr := evaluator.ExpandMacros(program, module.macros.Env)
+	if expandErr != nil {
+		return nil, fmt.Errorf("%s", expandErr.Inspect())
+	}
+
+	c.loading = append(c.loading, loadingModule{path: path, key: key})
+	defer func() { c.loading = c.loading[:len(c.loading)-1] }()
+
+	fn, err := c.compileModuleFunction(node, module, expanded.(*ast.Program))
+	if err != nil {
+		return nil, err
+	}
+	module.function = c.addConstant(fn)
+	module.global = root.defineModule(key, module)
+	return module, nil
+}
+
+// 读取并解析模块,标准库由所有标准库文件组成
+func loadModuleProgram(node *ast.UseExpression, path string) (*ast.Program, error) {
+	if path == evaluator.StdModuleName {
+		program, diagnostics, err := util.LoadStd()
+		if err != nil {
+			return nil, errorAt(node, "cannot load std: %s", err)
+		}
+		if len(diagnostics) != 0 {
+			return nil, errorAt(node, "cannot parse std: %s", diagnostics[0])
+		}
+		return program, nil
+	}
+
+	program, diagnostics, err := util.LoadMalFile(path)
+	if err != nil {
+		return nil, errorAt(node, "%s", err)
+	}
+	if len(diagnostics) != 0 {
+		return nil, errorAt(node, "cannot parse module %s: %s", path, diagnostics[0])
+	}
+	return program, nil
+}
+
+// 把模块编译为一个函数,函数返回由导出的名字构成的模块
+//
+// 模块有自己的根符号表,只有内置函数;模块用到的标准库的名字是外层函数的局部变量,
+// 由标准库模块的成员初始化。和解释器一样每个模块得到标准库绑定的副本,这些名字不会成为模块的成员
+func (c *Compiler) compileModuleFunction(node *ast.UseExpression, module *compiledModule, program *ast.Program) (*object.CompiledFunction, error) {
+	stdNames := []string{}
+	var std *compiledModule
+	if module.name != evaluator.StdModuleName {
+		var err error
+		std, err = c.compileModule(&ast.UseExpression{Token: node.Token, FileName: evaluator.StdModuleName + util.MAL_EXT})
+		if err != nil {
+			return nil, err
+		}
+		used := identifierNames(program)
+		for _, name := range std.members {
+			if used[name] {
+				stdNames = append(stdNames, name)
+			}
+		}
+	}
+
+	outer := c.symbolTable
+	c.symbolTable = newModuleSymbolTable(outer.globals())
+	defer func() { c.symbolTable = outer }()
+
+	if len(stdNames) == 0 {
+		fn, _, err := c.compileModuleBody(module, program)
+		return fn, err
+	}
+
+	c.enterScope()
+	c.symbolTable.Captured = map[string]bool{}
+	c.loadModule(std)
+	c.emit(code.OpPop)
+	for _, name := range stdNames {
+		c.symbolTable.Captured[name] = true
+		symbol := c.symbolTable.Define(name)
+		c.emit(code.OpGetGlobal, std.global)
+		c.emit(code.OpMember, c.addConstant(&object.String{Value: name}))
+		c.storeSymbol(symbol)
+	}
+
+	body, freeSymbols, err := c.compileModuleBody(module, program)
+	if err != nil {
+		c.leaveScope()
+		return nil, err
+	}
+	for _, s := range freeSymbols {
+		s.Cell = false
+		c.loadSymbol(s)
+	}
+	c.emit(code.OpClosure, c.addConstant(body), len(freeSymbols))
+	c.emit(code.OpCall, 0)
+	c.emit(code.OpReturnValue)
+
+	numLocals := c.symbolTable.numDefinitions
+	localNames := c.symbolTable.localNames
+	lines := c.scopes[c.scopeIndex].lines
+	instructions := c.leaveScope()
+	return &object.CompiledFunction{
+		Instructions: instructions,
+		NumLocals:    numLocals,
+		Lines:        lines,
+		LocalNames:   localNames,
+	}, nil
+}
+
+// 把模块的顶层语句编译为一个函数,顶层的名字是函数的局部变量,模块的成员是模块运行结束时的值
+func (c *Compiler) compileModuleBody(module *compiledModule, program *ast.Program) (*object.CompiledFunction, []Symbol, error) {
+	c.enterScope()
+	c.symbolTable.Captured = capturedNames(&ast.BlockStatement{Statements: program.Statements})
+	if err := c.Compile(program); err != nil {
+		c.leaveScope()
+		return nil, nil, err
+	}
+
+	exported := &object.Module{Exports: module.exports}
+	module.members = []string{}
+	for name, symbol := range c.symbolTable.store {
+		if symbol.Scope == LocalScope && exported.Exported(name) {
+			module.members = append(module.members, name)
+		}
+	}
+	sort.Strings(module.members)
+	for _, name := range module.members {
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: name}))
+		c.loadSymbol(c.symbolTable.store[name])
+	}
+	c.emit(code.OpModule, c.addConstant(&object.String{Value: module.name}), len(module.members)*2)
+	c.emit(code.OpReturnValue)
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	localNames, freeNames := c.symbolTable.localNames, c.symbolTable.freeNames()
+	lines := c.scopes[c.scopeIndex].lines
+	instructions := c.leaveScope()
+	return &object.CompiledFunction{
+		Instructions: instructions,
+		NumLocals:    numLocals,
+		Lines:        lines,
+		LocalNames:   localNames,
+		FreeNames:    freeNames,
+	}, freeSymbols, nil
+}
+
+// 编译函数字面量,name不为空时函数体内可以通过name递归调用自身
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral, name string) error {
+	c.enterScope()
+	c.symbolTable.Captured = capturedNames(node.Body)
+
+	if name != "" {
+		c.symbolTable.DefineFunctionName(name)
+	}
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+	// 被捕获的参数装入cell
+	for _, p := range node.Parameters {
+		if symbol, _ := c.symbolTable.Resolve(p.Value); symbol.Cell {
+			c.emit(code.OpGetLocal, symbol.Index)
+			c.emit(code.OpSetLocalCell, symbol.Index)
+		}
+	}
+
+	if err := c.Compile(node.Body); err != nil {
+		c.leaveScope()
+		return err
+	}
+	// 最后一个表达式语句的值作为返回值
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	localNames, freeNames := c.symbolTable.localNames, c.symbolTable.freeNames()
+	lines := c.scopes[c.scopeIndex].lines
+	instructions := c.leaveScope()
+
+	// 把捕获的变量压栈,由OpClosure收集到闭包中;cell本身被捕获,而不是cell中的值
+	for _, s := range freeSymbols {
+		s.Cell = false
+		c.loadSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		Lines:         lines,
+		Source:        object.FunctionSource(node.Parameters, node.Body),
+		LocalNames:    localNames,
+		FreeNames:     freeNames,
+	}
+	c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+	return nil
+}
+
+// 编译对自身名字赋值的函数 let f = fn() { f = 1 }
+// 和解释器一样,函数体中的名字指向let定义的绑定,所以先定义名字;
+// 局部变量先创建cell,闭包捕获这个cell,之后保存函数和函数体中的赋值都写入同一个cell
+func (c *Compiler) compileSelfAssigningFunction(node *ast.LetStatement, fn *ast.FunctionLiteral) error {
+	symbol, err := c.define(node, node.Name.Value)
+	if err != nil {
+		return err
+	}
+	if symbol.Cell {
+		c.emit(code.OpNull)
+		c.storeSymbol(symbol)
+	}
+	if err := c.compileFunction(fn, ""); err != nil {
+		return err
+	}
+	c.storeSymbol(symbol)
+	return nil
+}
+
+// 被内层函数引用的名字
+func capturedNames(body *ast.BlockStatement) map[string]bool {
+	names := make(map[string]bool)
+	ast.Modify(body, func(node ast.Node) ast.Node {
+		if fn, ok := node.(*ast.FunctionLiteral); ok {
+			ast.Modify(fn.Body, func(node ast.Node) ast.Node {
+				if ident, ok := node.(*ast.Identifier); ok {
+					names[ident.Value] = true
+				}
+				return node
+			})
+		}
+		return node
+	})
+	return names
+}
+
+// 节点中(包括内层函数中)被赋值的名字
+func assignedNames(node ast.Node) map[string]bool {
+	names := make(map[string]bool)
+	ast.Modify(node, func(node ast.Node) ast.Node {
+		if assign, ok := node.(*ast.AssignExpression); ok {
+			if ident, ok := assign.Target.(*ast.Identifier); ok {
+				names[ident.Value] = true
+			}
+		}
+		return node
+	})
+	return names
+}
+
+// 节点中出现的所有标识符
+func identifierNames(node ast.Node) map[string]bool {
+	names := make(map[string]bool)
+	ast.Modify(node, func(node ast.Node) ast.Node {
+		if ident, ok := node.(*ast.Identifier); ok {
+			names[ident.Value] = true
+		}
+		return node
+	})
+	return names
+}
+
+// 发出读取符号的指令
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch {
+	case s.Scope == GlobalScope && s.Cell:
+		c.emit(code.OpGetGlobalCell, s.Index)
+	case s.Scope == GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case s.Scope == LocalScope && s.Cell:
+		c.emit(code.OpGetLocalCell, s.Index)
+	case s.Scope == LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case s.Scope == BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case s.Scope == FreeScope && s.Cell:
+		c.emit(code.OpGetFreeCell, s.Index)
+	case s.Scope == FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case s.Scope == FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// 发出保存栈顶的值到符号的指令
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch {
+	case s.Scope == GlobalScope && s.Cell:
+		c.emit(code.OpSetGlobalCell, s.Index)
+	case s.Scope == GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case s.Scope == LocalScope && s.Cell:
+		c.emit(code.OpSetLocalCell, s.Index)
+	case s.Scope == LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case s.Scope == FreeScope:
+		c.emit(code.OpSetFreeCell, s.Index)
+	}
+}
+
+// 进入新的函数作用域
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{instructions: code.Instructions{}})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+// 离开函数作用域,返回函数体的指令
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+// 加入常量池,返回常量的下标
+func (c *Compiler) addConstant(obj object.Object) int {
+	key, ok := constantKey(obj)
+	if ok {
+		if index, ok := c.constantIndex[key]; ok {
+			return index
+		}
+	}
+	c.constants = append(c.constants, obj)
+	if ok {
+		c.constantIndex[key] = len(c.constants) - 1
+	}
+	return len(c.constants) - 1
+}
+
+// 整数、浮点数和字符串常量按类型和值合并,浮点数按位比较,0.0和-0.0是不同的常量
+func constantKey(obj object.Object) (constant, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return constant{obj.Type(), obj.Value}, true
+	case *object.Float:
+		return constant{obj.Type(), math.Float64bits(obj.Value)}, true
+	case *object.String:
+		return constant{obj.Type(), obj.Value}, true
+	}
+	return constant{}, false
+}
+
+// 操作数放不下时记录编译错误,字节码中的操作数会被截断,不能运行
+func (c *Compiler) checkOperands(op code.Opcode, operands ...int) {
+	if err := code.CheckOperands(op, operands...); err != nil && c.err == nil {
+		c.err = fmt.Errorf("%s: program too large: %s", c.position, err)
+	}
+}
+
+// 发出指令,返回指令的起始位置
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands...)
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+	c.addLine(pos)
+
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	return posNewInstruction
+}
+
+// 记录指令的源码位置,和上一条记录的位置相同时不重复记录
+func (c *Compiler) addLine(offset int) {
+	if !c.position.IsValid() {
+		return
+	}
+	scope := &c.scopes[c.scopeIndex]
+	if n := len(scope.lines); n > 0 && scope.lines[n-1].Pos == c.position {
+		return
+	}
+	scope.lines = append(scope.lines, code.LineEntry{Offset: offset, Pos: c.position})
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.previousInstruction = scope.lastInstruction
+	scope.lastInstruction = EmittedInstruction{Opcode: op, Position: pos}
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+// 删除最后一条OpPop,让块的值留在栈上
+func (c *Compiler) removeLastPop() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.instructions = scope.instructions[:scope.lastInstruction.Position]
+	scope.lastInstruction = scope.previousInstruction
+	for len(scope.lines) > 0 && scope.lines[len(scope.lines)-1].Offset >= len(scope.instructions) {
+		scope.lines = scope.lines[:len(scope.lines)-1]
+	}
+}
+
+// 把函数体最后的OpPop换成OpReturnValue,返回最后一个表达式的值
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+// 替换pos处的指令(指令长度相同)
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+// 回填pos处指令的操作数
+func (c *Compiler) changeOperand(opPos int, operands ...int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, operands...)
+	newInstruction := code.Make(op, operands...)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		Lines:        c.scopes[c.scopeIndex].lines,
+		GlobalNames:  c.symbolTable.root().globalNames,
+	}
+}
+
+// 在当前作用域中定义名字
+func (c *Compiler) define(node ast.Node, name string) (Symbol, error) {
+	symbol := c.symbolTable.Define(name)
+	if symbol.Scope == LocalScope && symbol.Index > 255 {
+		return symbol, errorAt(node, "too many local variables")
+	}
+	return symbol, nil
+}
+
+// 哈希字面量的键,map的遍历顺序不固定,按键的源码排序保证编译结果稳定
+func sortedKeys(node *ast.HashLiteral) []ast.Expression {
+	keys := []ast.Expression{}
+	for k := range node.Pairs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+// 编译错误,带有节点的源码位置
+func errorAt(node ast.Node, format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", node.Pos(), fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,329 @@
+// compiler/malc.go
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"malang/code"
+	"malang/evaluator"
+	"malang/object"
+	"malang/token"
+	"math"
+)
+
+// .malc 编译后的程序文件,整数都是大端序
+//
+//	头部:   magic "MALC" | 版本 uint16 | 标志 uint16 | 指令集指纹 uint32 | payload长度 uint32
+//	payload: 内置函数名表 | [文件名表 | 全局变量名表] | 主程序 | 常量池
+//	尾部:   头部和payload的crc32校验和 uint32
+//
+// 函数(包括主程序): 局部绑定数 uint32 | 参数个数 uint32 | 源码表示 | 指令 | [位置表 | 局部绑定名表 | 自由变量名表]
+// 标志包含MalcDebug时才有文件名表、全局变量名表、位置表和函数的名字表
+// 操作码按编号写入,指令集变化后旧文件通过指纹拒绝;内置函数按名字对应,加载时换成当前的下标
+const (
+	MalcMagic   = "MALC"
+	MalcVersion = 3
+
+	MalcDebug uint16 = 1 << 0 // 包含调试信息
+
+	malcHeaderSize  = 16
+	malcTrailerSize = 4
+)
+
+// 常量的类型标记
+const (
+	constInteger byte = iota + 1
+	constFloat
+	constString
+	constFunction
+)
+
+var errTruncated = errors.New("truncated bytecode file")
+
+// 把字节码编码为.malc文件的内容,debug为false时不写入位置表
+func EncodeBytecode(b *Bytecode, debug bool) ([]byte, error) {
+	e := &encoder{debug: debug, files: map[string]int{}}
+
+	main := &object.CompiledFunction{Instructions: b.Instructions, Lines: b.Lines}
+	e.function(main)
+	e.uint32(len(b.Constants))
+	for i, constant := range b.Constants {
+		if err := e.constant(constant); err != nil {
+			return nil, fmt.Errorf("constant %d: %s", i, err)
+		}
+	}
+
+	// 文件名在编码位置表时收集,和其它名字表一起写在payload的最前面
+	tables := &encoder{}
+	tables.strings(evaluator.BuiltinNames)
+	flags := uint16(0)
+	if debug {
+		flags |= MalcDebug
+		tables.strings(e.fileNames)
+		tables.strings(b.GlobalNames)
+	}
+	var payload bytes.Buffer
+	payload.Write(tables.buf.Bytes())
+	payload.Write(e.buf.Bytes())
+
+	var out bytes.Buffer
+	out.WriteString(MalcMagic)
+	binary.Write(&out, binary.BigEndian, uint16(MalcVersion))
+	binary.Write(&out, binary.BigEndian, flags)
+	binary.Write(&out, binary.BigEndian, code.Fingerprint())
+	binary.Write(&out, binary.BigEndian, uint32(payload.Len()))
+	out.Write(payload.Bytes())
+	binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(out.Bytes()))
+	return out.Bytes(), nil
+}
+
+// 解码.malc文件的内容,检查版本、指令集和校验和,拒绝截断或损坏的文件
+func DecodeBytecode(data []byte) (*Bytecode, error) {
+	n := len(data)
+	if n > len(MalcMagic) {
+		n = len(MalcMagic)
+	}
+	if string(data[:n]) != MalcMagic[:n] {
+		return nil, errors.New("not a malang bytecode file")
+	}
+	if len(data) < malcHeaderSize+malcTrailerSize {
+		return nil, errTruncated
+	}
+	if version := binary.BigEndian.Uint16(data[4:]); version != MalcVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %d (want %d)", version, MalcVersion)
+	}
+	if fingerprint := binary.BigEndian.Uint32(data[8:]); fingerprint != code.Fingerprint() {
+		return nil, fmt.Errorf("bytecode file was compiled for a different instruction set (%08x, want %08x), recompile it", fingerprint, code.Fingerprint())
+	}
+	flags := binary.BigEndian.Uint16(data[6:])
+	length := int(binary.BigEndian.Uint32(data[12:]))
+	end := malcHeaderSize + length
+	if len(data) < end+malcTrailerSize {
+		return nil, errTruncated
+	}
+	if len(data) > end+malcTrailerSize {
+		return nil, errors.New("corrupted bytecode file: unexpected data after checksum")
+	}
+	if crc32.ChecksumIEEE(data[:end]) != binary.BigEndian.Uint32(data[end:]) {
+		return nil, errors.New("corrupted bytecode file: checksum mismatch")
+	}
+
+	d := &decoder{data: data[malcHeaderSize:end], debug: flags&MalcDebug != 0}
+	builtins := d.strings()
+	var globalNames []string
+	if d.debug {
+		d.files = d.strings()
+		globalNames = d.strings()
+	}
+	main := d.function()
+	constants := make([]object.Object, d.count())
+	for i := range constants {
+		constants[i] = d.constant()
+	}
+	if d.err == nil && d.pos != len(d.data) {
+		d.fail("unexpected data after constants")
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	bytecode := &Bytecode{Instructions: main.Instructions, Constants: constants, Lines: main.Lines, GlobalNames: globalNames}
+	if err := verifyBytecode(bytecode, builtins); err != nil {
+		return nil, err
+	}
+	return bytecode, nil
+}
+
+type encoder struct {
+	buf   bytes.Buffer
+	debug bool
+
+	files     map[string]int // 文件名在文件名表中的下标
+	fileNames []string
+}
+
+func (e *encoder) uint32(n int) {
+	binary.Write(&e.buf, binary.BigEndian, uint32(n))
+}
+
+func (e *encoder) string(s string) {
+	e.uint32(len(s))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) strings(ss []string) {
+	e.uint32(len(ss))
+	for _, s := range ss {
+		e.string(s)
+	}
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(constInteger)
+		binary.Write(&e.buf, binary.BigEndian, obj.Value)
+	case *object.Float:
+		e.buf.WriteByte(constFloat)
+		binary.Write(&e.buf, binary.BigEndian, math.Float64bits(obj.Value))
+	case *object.String:
+		e.buf.WriteByte(constString)
+		e.string(obj.Value)
+	case *object.CompiledFunction:
+		e.buf.WriteByte(constFunction)
+		e.function(obj)
+	default:
+		return fmt.Errorf("cannot serialize %s", obj.Type())
+	}
+	return nil
+}
+
+func (e *encoder) function(fn *object.CompiledFunction) {
+	e.uint32(fn.NumLocals)
+	e.uint32(fn.NumParameters)
+	e.string(fn.Source)
+	e.uint32(len(fn.Instructions))
+	e.buf.Write(fn.Instructions)
+	if !e.debug {
+		return
+	}
+
+	e.uint32(len(fn.Lines))
+	for _, entry := range fn.Lines {
+		file, ok := e.files[entry.Pos.File]
+		if !ok {
+			file = len(e.fileNames)
+			e.files[entry.Pos.File] = file
+			e.fileNames = append(e.fileNames, entry.Pos.File)
+		}
+		e.uint32(entry.Offset)
+		e.uint32(file)
+		e.uint32(entry.Pos.Line)
+		e.uint32(entry.Pos.Column)
+	}
+	e.strings(fn.LocalNames)
+	e.strings(fn.FreeNames)
+}
+
+// 解码payload,出错后记录第一个错误,之后的读取都返回零值
+type decoder struct {
+	data  []byte
+	pos   int
+	debug bool
+	files []string
+	err   error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("corrupted bytecode file: "+format, args...)
+	}
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data)-d.pos {
+		d.fail("unexpected end of data")
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) uint32() int {
+	b := d.read(4)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.read(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// 读取元素个数,个数不可能超过剩余的字节数
+func (d *decoder) count() int {
+	n := d.uint32()
+	if n > len(d.data)-d.pos {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) string() string {
+	return string(d.read(d.uint32()))
+}
+
+func (d *decoder) strings() []string {
+	ss := make([]string, d.count())
+	for i := range ss {
+		ss[i] = d.string()
+	}
+	return ss
+}
+
+func (d *decoder) constant() object.Object {
+	tag := d.read(1)
+	if tag == nil {
+		return nil
+	}
+	switch tag[0] {
+	case constInteger:
+		return &object.Integer{Value: int64(d.uint64())}
+	case constFloat:
+		return &object.Float{Value: math.Float64frombits(d.uint64())}
+	case constString:
+		return &object.String{Value: d.string()}
+	case constFunction:
+		return d.function()
+	default:
+		d.fail("unknown constant tag %d", tag[0])
+		return nil
+	}
+}
+
+func (d *decoder) function() *object.CompiledFunction {
+	fn := &object.CompiledFunction{
+		NumLocals:     d.uint32(),
+		NumParameters: d.uint32(),
+		Source:        d.string(),
+	}
+	// 复制指令,检查时会改写内置函数的下标
+	fn.Instructions = append(code.Instructions{}, d.read(d.uint32())...)
+	if !d.debug {
+		return fn
+	}
+
+	n := d.count()
+	if n > 0 {
+		fn.Lines = make(code.LineTable, n)
+	}
+	for i := range fn.Lines {
+		offset, file := d.uint32(), d.uint32()
+		if d.err == nil && file >= len(d.files) {
+			d.fail("unknown file %d", file)
+		}
+		if d.err != nil {
+			return fn
+		}
+		fn.Lines[i] = code.LineEntry{
+			Offset: offset,
+			Pos:    token.Position{File: d.files[file], Line: d.uint32(), Column: d.uint32()},
+		}
+	}
+	fn.LocalNames = d.strings()
+	fn.FreeNames = d.strings()
+	return fn
+}
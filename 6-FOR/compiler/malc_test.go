@@ -0,0 +1,256 @@
+package compiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"malang/code"
+	"malang/evaluator"
+	"malang/lexer"
+	"malang/object"
+	"malang/parser"
+	"reflect"
+	"testing"
+)
+
+func compileForMalc(t *testing.T, input string) *Bytecode {
+	t.Helper()
+
+	p := parser.New(lexer.NewWithFile("main.mal", input))
+	comp := New()
+	if err := comp.Compile(p.ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func TestEncodeDecodeBytecode(t *testing.T) {
+	bytecode := compileForMalc(t, `
+	let add = fn(a, b) { let c = a + b; c * 1.5 };
+	let greet = fn(name) { "hello, " + name };
+	puts(greet("你好"), add(-1, 9223372036854775807));
+	`)
+
+	for _, debug := range []bool{true, false} {
+		data, err := EncodeBytecode(bytecode, debug)
+		if err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+		decoded, err := DecodeBytecode(data)
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+
+		if err := testInstructions([]code.Instructions{bytecode.Instructions}, decoded.Instructions); err != nil {
+			t.Errorf("wrong main instructions: %s", err)
+		}
+		if len(decoded.Constants) != len(bytecode.Constants) {
+			t.Fatalf("wrong number of constants. want=%d, got=%d", len(bytecode.Constants), len(decoded.Constants))
+		}
+		for i, constant := range bytecode.Constants {
+			want, got := constant, decoded.Constants[i]
+			if fn, ok := constant.(*object.CompiledFunction); ok && !debug {
+				want = &object.CompiledFunction{Instructions: fn.Instructions, NumLocals: fn.NumLocals, NumParameters: fn.NumParameters, Source: fn.Source}
+			}
+			if !reflect.DeepEqual(want, got) {
+				t.Errorf("constant %d - want=%+v, got=%+v", i, want, got)
+			}
+		}
+
+		if debug && !reflect.DeepEqual(bytecode.Lines, decoded.Lines) {
+			t.Errorf("wrong lines. want=%+v, got=%+v", bytecode.Lines, decoded.Lines)
+		}
+		if !debug && decoded.Lines != nil {
+			t.Errorf("debug info should be stripped. got=%+v", decoded.Lines)
+		}
+	}
+}
+
+func TestDecodeInvalidBytecode(t *testing.T) {
+	data, err := EncodeBytecode(compileForMalc(t, `let f = fn(x) { x + 1 }; f(2)`), true)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	modified := func(change func(b []byte) []byte) []byte {
+		b := append([]byte{}, data...)
+		return change(b)
+	}
+	// 修改payload后重新计算校验和,模拟写入错误的文件
+	resealed := func(change func(b []byte)) []byte {
+		return modified(func(b []byte) []byte {
+			change(b)
+			end := len(b) - 4
+			binary.BigEndian.PutUint32(b[end:], crc32.ChecksumIEEE(b[:end]))
+			return b
+		})
+	}
+
+	ts := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"empty", []byte{}, "truncated bytecode file"},
+		{"source file", []byte("let a = 1;"), "not a malang bytecode file"},
+		{"header only", data[:malcHeaderSize], "truncated bytecode file"},
+		{"truncated", data[:len(data)-1], "truncated bytecode file"},
+		{"trailing data", append(append([]byte{}, data...), 0), "corrupted bytecode file: unexpected data after checksum"},
+		{"version", modified(func(b []byte) []byte { b[5] = 99; return b }), "unsupported bytecode version 99 (want 3)"},
+		{"flipped byte", modified(func(b []byte) []byte { b[malcHeaderSize+10] ^= 0xff; return b }), "corrupted bytecode file: checksum mismatch"},
+		{"bad checksum", modified(func(b []byte) []byte { b[len(b)-1] ^= 1; return b }), "corrupted bytecode file: checksum mismatch"},
+		{"huge count", resealed(func(b []byte) { binary.BigEndian.PutUint32(b[malcHeaderSize:], 1<<30) }), "corrupted bytecode file: unexpected end of data"},
+	}
+	for _, tt := range ts {
+		_, err := DecodeBytecode(tt.data)
+		if err == nil {
+			t.Errorf("%s: expected error %q", tt.name, tt.expected)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err.Error())
+		}
+	}
+}
+
+// 校验和正确但指令流有问题的文件,虚拟机运行之前就要拒绝
+func TestDecodeInvalidInstructions(t *testing.T) {
+	concat := func(ins ...code.Instructions) code.Instructions { return concatInstructions(ins) }
+	function := func(numLocals int, ins ...code.Instructions) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concat(ins...), NumLocals: numLocals}
+	}
+
+	ts := []struct {
+		name         string
+		instructions code.Instructions
+		constants    []object.Object
+		expected     string
+	}{
+		{"constant out of range", concat(code.Make(code.OpConstant, 7), code.Make(code.OpPop)), nil,
+			"corrupted bytecode file: main program at 0: constant index 7 out of range"},
+		{"truncated operand", code.Instructions{byte(code.OpConstant)}, nil,
+			"corrupted bytecode file: main program at 0: truncated instruction OpConstant"},
+		{"pop on empty stack", concat(code.Make(code.OpPop)), nil,
+			"corrupted bytecode file: main program at 0: stack underflow in OpPop"},
+		{"free in main program", concat(code.Make(code.OpGetFree, 3), code.Make(code.OpPop)), nil,
+			"corrupted bytecode file: main program at 0: free variable index 3 out of range"},
+		{"free out of range",
+			concat(code.Make(code.OpNull), code.Make(code.OpClosure, 0, 1), code.Make(code.OpPop)),
+			[]object.Object{function(0, code.Make(code.OpGetFree, 3), code.Make(code.OpReturnValue))},
+			"corrupted bytecode file: function 0 at 0: free variable index 3 out of range"},
+		{"unknown opcode", code.Instructions{255}, nil,
+			"corrupted bytecode file: main program at 0: unknown opcode 255"},
+		{"jump into operand", concat(code.Make(code.OpJump, 1)), nil,
+			"corrupted bytecode file: main program at 0: jump target 1 is not an instruction"},
+		{"jump past end", concat(code.Make(code.OpJump, 9)), nil,
+			"corrupted bytecode file: main program at 0: jump target 9 is not an instruction"},
+		{"inconsistent stack",
+			concat(code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 5), code.Make(code.OpNull), code.Make(code.OpNull)), nil,
+			"corrupted bytecode file: main program at 5: inconsistent stack height 0 and 1"},
+		{"local in main program", concat(code.Make(code.OpGetLocal, 0), code.Make(code.OpPop)), nil,
+			"corrupted bytecode file: main program at 0: local index 0 out of range"},
+		{"return in main program", concat(code.Make(code.OpReturn)), nil,
+			"corrupted bytecode file: main program at 0: OpReturn outside a function"},
+		{"closure of a string", concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)), []object.Object{&object.String{Value: "f"}},
+			"corrupted bytecode file: main program at 0: constant 0 is not a function"},
+		{"member name", concat(code.Make(code.OpNull), code.Make(code.OpMember, 0), code.Make(code.OpPop)), []object.Object{&object.Integer{Value: 1}},
+			"corrupted bytecode file: main program at 1: constant 0 is not a string"},
+		{"odd hash", concat(code.Make(code.OpNull), code.Make(code.OpHash, 1), code.Make(code.OpPop)), nil,
+			"corrupted bytecode file: main program at 1: odd number of hash elements 1"},
+		{"call without callee", concat(code.Make(code.OpNull), code.Make(code.OpCall, 1), code.Make(code.OpPop)), nil,
+			"corrupted bytecode file: main program at 1: stack underflow in OpCall"},
+		{"builtin out of range", concat(code.Make(code.OpGetBuiltin, 255), code.Make(code.OpPop)), nil,
+			"corrupted bytecode file: main program at 0: builtin index 255 out of range"},
+		{"missing return",
+			concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+			[]object.Object{function(0, code.Make(code.OpNull))},
+			"corrupted bytecode file: function 0 at 0: missing return at end of function"},
+		{"local out of range",
+			concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+			[]object.Object{function(1, code.Make(code.OpGetLocal, 1), code.Make(code.OpReturnValue))},
+			"corrupted bytecode file: function 0 at 0: local index 1 out of range"},
+	}
+	for _, tt := range ts {
+		data, err := EncodeBytecode(&Bytecode{Instructions: tt.instructions, Constants: tt.constants}, false)
+		if err != nil {
+			t.Fatalf("%s: encode error: %s", tt.name, err)
+		}
+		_, err = DecodeBytecode(data)
+		if err == nil {
+			t.Errorf("%s: expected error %q", tt.name, tt.expected)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err.Error())
+		}
+	}
+}
+
+// 内置函数按名字保存,加载时换成当前的下标;操作码变化后拒绝旧文件
+func TestDecodeBuiltinsAndInstructionSet(t *testing.T) {
+	bytecode := compileForMalc(t, "len; puts;")
+	data, err := EncodeBytecode(bytecode, false)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	decoded, err := DecodeBytecode(data)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if err := testInstructions([]code.Instructions{bytecode.Instructions}, decoded.Instructions); err != nil {
+		t.Errorf("wrong instructions: %s", err)
+	}
+
+	// 文件中的下标按名字换成当前的下标
+	remapped := &Bytecode{Instructions: concatInstructions([]code.Instructions{code.Make(code.OpGetBuiltin, 1), code.Make(code.OpPop)})}
+	if err := verifyBytecode(remapped, []string{"len", "puts"}); err != nil {
+		t.Fatalf("verify error: %s", err)
+	}
+	puts := 0
+	for i, name := range evaluator.BuiltinNames {
+		if name == "puts" {
+			puts = i
+		}
+	}
+	want := concatInstructions([]code.Instructions{code.Make(code.OpGetBuiltin, puts), code.Make(code.OpPop)})
+	if remapped.Instructions.String() != want.String() {
+		t.Errorf("wrong remapped instructions.\nwant=%q\ngot=%q", want, remapped.Instructions)
+	}
+
+	// 内置函数名表在payload的最前面: 个数 | 每个名字的长度和内容,把len改名为Xen
+	renamed := func(data []byte) []byte {
+		b := append([]byte{}, data...)
+		pos := malcHeaderSize + 4
+		for _, name := range evaluator.BuiltinNames {
+			if name == "len" {
+				b[pos+4] = 'X'
+			}
+			pos += 4 + len(name)
+		}
+		end := len(b) - 4
+		binary.BigEndian.PutUint32(b[end:], crc32.ChecksumIEEE(b[:end]))
+		return b
+	}
+	expected := "bytecode file uses unknown builtin Xen"
+	if _, err := DecodeBytecode(renamed(data)); err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+
+	// 没有用到的内置函数不存在时仍然可以加载
+	data, err = EncodeBytecode(compileForMalc(t, "puts;"), false)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if _, err := DecodeBytecode(renamed(data)); err != nil {
+		t.Errorf("unused builtin should not matter. got=%s", err)
+	}
+
+	b := append([]byte{}, data...)
+	b[8] ^= 0xff
+	end := len(b) - 4
+	binary.BigEndian.PutUint32(b[end:], crc32.ChecksumIEEE(b[:end]))
+	expected = fmt.Sprintf("bytecode file was compiled for a different instruction set (%08x, want %08x), recompile it",
+		code.Fingerprint()^0xff000000, code.Fingerprint())
+	if _, err := DecodeBytecode(b); err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+}
@@ -0,0 +1,219 @@
+// compiler/symbol_table.go
+package compiler
+
+import "malang/object"
+
+// 符号的作用域
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"     // 闭包捕获的外层局部变量
+	FunctionScope SymbolScope = "FUNCTION" // 函数自身的名字,用于递归调用
+)
+
+// 符号: 名字、作用域和在该作用域存储中的下标
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+	Cell  bool // 被闭包捕获的局部变量(包括顶层块作用域中的全局变量)保存在cell中,闭包和外层函数共享同一个变量
+}
+
+// 符号表,记录let绑定的名字对应的存储位置
+// 每个函数有自己的符号表,outer指向外层函数(或全局)的符号表
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+
+	FreeSymbols []Symbol // 函数引用的外层局部变量,按捕获顺序排列
+
+	Captured map[string]bool // 被内层函数引用的名字,在这里定义的局部变量和块作用域中的全局变量需要保存在cell中
+
+	blocks       []map[string]shadowedSymbol // 正在编译的块作用域(range循环体),记录被遮蔽的符号
+	blockGlobals map[int]bool                // 在块作用域中定义的全局变量,每次执行块都是新的变量,闭包像局部变量一样捕获它们
+
+	modules map[string]*compiledModule // 已编译的模块,按文件绝对路径记录,只在全局符号表中使用
+	program *SymbolTable               // 模块的根符号表指向程序的全局符号表,模块和全局变量记录在那里
+
+	globalNames []string // 全局变量的名字,下标是全局变量的下标,运行时报错使用
+	localNames  []string // 局部绑定的名字,下标是局部绑定的下标,运行时报错使用
+}
+
+// 编译后的模块
+type compiledModule struct {
+	name     string
+	exports  map[string]bool // export的名字,没有使用export时为nil
+	macros   *object.Module  // 只包含模块中的宏,宏在编译前展开,use时不需要绑定
+	function int             // 运行模块的函数在常量池中的下标
+	global   int             // 保存模块的全局变量的下标
+	members  []string        // 模块运行结束时的成员,按名字排序
+}
+
+// 块作用域中定义的名字原来对应的符号
+type shadowedSymbol struct {
+	symbol Symbol
+	ok     bool
+}
+
+func NewSymbolTable() *SymbolTable {
+	s := make(map[string]Symbol)
+	return &SymbolTable{store: s, blockGlobals: make(map[int]bool)}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// 定义符号,同一作用域中重复定义同一个名字时复用原来的下标
+// 在块作用域中第一次定义的名字总是分配新的下标,离开块后恢复外层的符号
+func (s *SymbolTable) Define(name string) Symbol {
+	scope := GlobalScope
+	if s.Outer != nil {
+		scope = LocalScope
+	}
+	if len(s.blocks) > 0 {
+		block := s.blocks[len(s.blocks)-1]
+		if _, defined := block[name]; !defined {
+			symbol, ok := s.store[name]
+			block[name] = shadowedSymbol{symbol: symbol, ok: ok}
+			return s.define(name, scope)
+		}
+	}
+	if symbol, ok := s.store[name]; ok && symbol.Scope == scope {
+		return symbol
+	}
+	return s.define(name, scope)
+}
+
+func (s *SymbolTable) define(name string, scope SymbolScope) Symbol {
+	symbol := Symbol{Name: name, Scope: scope, Index: s.numDefinitions}
+	symbol.Cell = s.Captured[name] && (scope == LocalScope || len(s.blocks) > 0)
+	s.store[name] = symbol
+	s.numDefinitions++
+	if scope == LocalScope {
+		s.localNames = append(s.localNames, name)
+	}
+	if scope == GlobalScope {
+		s.globalNames = append(s.globalNames, name)
+		if len(s.blocks) > 0 {
+			s.blockGlobals[symbol.Index] = true
+		}
+	}
+	return symbol
+}
+
+// 进入块作用域
+func (s *SymbolTable) EnterBlock() {
+	s.blocks = append(s.blocks, make(map[string]shadowedSymbol))
+}
+
+// 离开块作用域,块中定义的名字不再可见
+func (s *SymbolTable) LeaveBlock() {
+	block := s.blocks[len(s.blocks)-1]
+	s.blocks = s.blocks[:len(s.blocks)-1]
+
+	for name, shadowed := range block {
+		if shadowed.ok {
+			s.store[name] = shadowed.symbol
+		} else {
+			delete(s.store, name)
+		}
+	}
+}
+
+// 定义内置函数,下标是内置函数列表中的下标
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Scope: BuiltinScope, Index: index}
+	s.store[name] = symbol
+	return symbol
+}
+
+// 定义函数自身的名字,不占用局部绑定
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Scope: FunctionScope, Index: 0}
+	s.store[name] = symbol
+	return symbol
+}
+
+// 自由变量的名字,下标是自由变量的下标
+func (s *SymbolTable) freeNames() []string {
+	names := make([]string, len(s.FreeSymbols))
+	for i, symbol := range s.FreeSymbols {
+		names[i] = symbol.Name
+	}
+	return names
+}
+
+// 把外层的局部变量定义为自由变量
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1, Cell: original.Cell}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// 查找符号,在外层找到的局部变量会成为当前函数的自由变量
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return symbol, ok
+	}
+
+	symbol, ok = s.Outer.Resolve(name)
+	if !ok {
+		return symbol, ok
+	}
+	if symbol.Scope == BuiltinScope || symbol.Scope == GlobalScope && !s.isBlockGlobal(symbol) {
+		return symbol, ok
+	}
+	return s.defineFree(symbol), true
+}
+
+// 是否是块作用域中定义的全局变量,这类变量每次迭代都不同,需要像局部变量一样被捕获
+func (s *SymbolTable) isBlockGlobal(symbol Symbol) bool {
+	return s.root().blockGlobals[symbol.Index]
+}
+
+// 全局符号表
+func (s *SymbolTable) root() *SymbolTable {
+	root := s
+	for root.Outer != nil {
+		root = root.Outer
+	}
+	return root
+}
+
+// 保存全局变量和模块的符号表,在模块中是导入模块的程序的全局符号表
+func (s *SymbolTable) globals() *SymbolTable {
+	root := s.root()
+	if root.program != nil {
+		return root.program
+	}
+	return root
+}
+
+// 模块的根符号表,只有内置函数,模块不能访问导入者的全局变量
+func newModuleSymbolTable(program *SymbolTable) *SymbolTable {
+	s := NewSymbolTableWithBuiltins()
+	s.program = program
+	return s
+}
+
+// 记录编译后的模块,为模块分配一个没有名字的全局变量
+func (s *SymbolTable) defineModule(key string, module *compiledModule) int {
+	if s.modules == nil {
+		s.modules = make(map[string]*compiledModule)
+	}
+	s.modules[key] = module
+	s.globalNames = append(s.globalNames, module.name)
+	s.numDefinitions++
+	return s.numDefinitions - 1
+}
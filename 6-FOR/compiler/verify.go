@@ -0,0 +1,331 @@
+// compiler/verify.go
+package compiler
+
+import (
+	"fmt"
+	"malang/code"
+	"malang/evaluator"
+	"malang/object"
+)
+
+// 二元运算指令,索引复合赋值的操作数也必须是其中之一
+var binaryOps = map[code.Opcode]bool{
+	code.OpAdd: true, code.OpSub: true, code.OpMul: true, code.OpDiv: true, code.OpMod: true, code.OpPow: true,
+	code.OpBitAnd: true, code.OpBitOr: true, code.OpBitXor: true, code.OpShl: true, code.OpShr: true,
+	code.OpGreaterThan: true, code.OpGreaterEqual: true, code.OpLessThan: true, code.OpLessEqual: true,
+	code.OpEqual: true, code.OpNotEqual: true,
+}
+
+// 解码出的一条指令
+type instruction struct {
+	pos      int
+	op       code.Opcode
+	name     string
+	operands []int
+}
+
+// 待检查的函数,主程序的下标为-1
+type verifiedFunction struct {
+	index        int
+	fn           *object.CompiledFunction
+	instructions []instruction
+	starts       map[int]int // 指令的偏移对应instructions中的下标
+	numFree      int
+}
+
+// 检查解码出的字节码,拒绝会让虚拟机越界或崩溃的指令流:
+// 未知的操作码、不完整的操作数、越界的常量、局部绑定、自由变量和内置函数下标、
+// 跳到指令中间的跳转以及会弹空栈的指令序列
+//
+// builtins是文件中的内置函数名表,内置函数的下标换成当前内置函数列表中的下标
+func verifyBytecode(b *Bytecode, builtins []string) error {
+	v := &verifier{constants: b.Constants, builtins: builtins, numFree: map[int]int{}}
+
+	functions := []*verifiedFunction{{index: -1, fn: &object.CompiledFunction{Instructions: b.Instructions}}}
+	for i, constant := range b.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			functions = append(functions, &verifiedFunction{index: i, fn: fn})
+		}
+	}
+
+	// 先检查每条指令,同时收集构建闭包时每个函数的自由变量个数
+	for _, f := range functions {
+		if err := v.decode(f); err != nil {
+			return err
+		}
+	}
+	for _, f := range functions {
+		f.numFree = v.numFree[f.index]
+		if err := v.checkFree(f); err != nil {
+			return err
+		}
+		if err := v.checkStack(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type verifier struct {
+	constants []object.Object
+	builtins  []string
+	numFree   map[int]int // 函数常量构建为闭包时的自由变量个数,取所有构建位置中最少的个数
+}
+
+func (f *verifiedFunction) fail(pos int, format string, args ...interface{}) error {
+	where := "main program"
+	if f.index >= 0 {
+		where = fmt.Sprintf("function %d", f.index)
+	}
+	return fmt.Errorf("corrupted bytecode file: %s at %d: "+format, append([]interface{}{where, pos}, args...)...)
+}
+
+// 拆分指令并检查不依赖控制流的操作数
+func (v *verifier) decode(f *verifiedFunction) error {
+	ins := f.fn.Instructions
+	if f.fn.NumParameters > f.fn.NumLocals {
+		return f.fail(0, "%d parameters but %d locals", f.fn.NumParameters, f.fn.NumLocals)
+	}
+
+	f.starts = map[int]int{}
+	for pos := 0; pos < len(ins); {
+		def, err := code.Lookup(ins[pos])
+		if err != nil {
+			return f.fail(pos, "unknown opcode %d", ins[pos])
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if pos+1+width > len(ins) {
+			return f.fail(pos, "truncated instruction %s", def.Name)
+		}
+		operands, _ := code.ReadOperands(def, ins[pos+1:])
+		in := instruction{pos: pos, op: code.Opcode(ins[pos]), name: def.Name, operands: operands}
+		if err := v.checkOperands(f, in); err != nil {
+			return err
+		}
+
+		f.starts[pos] = len(f.instructions)
+		f.instructions = append(f.instructions, in)
+		pos += 1 + width
+	}
+
+	for _, in := range f.instructions {
+		switch in.op {
+		case code.OpJump, code.OpJumpNotTruthy, code.OpIterNext:
+			if err := f.checkTarget(in, in.operands[0]); err != nil {
+				return err
+			}
+		case code.OpGetModule:
+			if err := f.checkTarget(in, in.operands[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *verifier) checkOperands(f *verifiedFunction, in instruction) error {
+	switch in.op {
+	case code.OpConstant:
+		if in.operands[0] >= len(v.constants) {
+			return f.fail(in.pos, "constant index %d out of range", in.operands[0])
+		}
+	case code.OpClosure:
+		index := in.operands[0]
+		if index >= len(v.constants) {
+			return f.fail(in.pos, "constant index %d out of range", index)
+		}
+		if _, ok := v.constants[index].(*object.CompiledFunction); !ok {
+			return f.fail(in.pos, "constant %d is not a function", index)
+		}
+		if numFree, ok := v.numFree[index]; !ok || in.operands[1] < numFree {
+			v.numFree[index] = in.operands[1]
+		}
+	case code.OpModule, code.OpMember:
+		index := in.operands[0]
+		if index >= len(v.constants) {
+			return f.fail(in.pos, "constant index %d out of range", index)
+		}
+		if _, ok := v.constants[index].(*object.String); !ok {
+			return f.fail(in.pos, "constant %d is not a string", index)
+		}
+		if in.op == code.OpModule && in.operands[1]%2 != 0 {
+			return f.fail(in.pos, "odd number of module elements %d", in.operands[1])
+		}
+	case code.OpHash:
+		if in.operands[0]%2 != 0 {
+			return f.fail(in.pos, "odd number of hash elements %d", in.operands[0])
+		}
+	case code.OpGetLocal, code.OpSetLocal, code.OpGetLocalCell, code.OpSetLocalCell:
+		if in.operands[0] >= f.fn.NumLocals {
+			return f.fail(in.pos, "local index %d out of range", in.operands[0])
+		}
+	case code.OpClearLocals:
+		if in.operands[0]+in.operands[1] > f.fn.NumLocals {
+			return f.fail(in.pos, "locals %d to %d out of range", in.operands[0], in.operands[0]+in.operands[1])
+		}
+	case code.OpGetBuiltin:
+		if in.operands[0] >= len(v.builtins) {
+			return f.fail(in.pos, "builtin index %d out of range", in.operands[0])
+		}
+		// 内置函数按名字对应,文件写入之后新增的内置函数不影响旧文件
+		name := v.builtins[in.operands[0]]
+		index := -1
+		for i, builtin := range evaluator.BuiltinNames {
+			if builtin == name {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return fmt.Errorf("bytecode file uses unknown builtin %s", name)
+		}
+		f.fn.Instructions[in.pos+1] = byte(index)
+	case code.OpSetIndex:
+		if in.operands[0] != 0 && !binaryOps[code.Opcode(in.operands[0])] {
+			return f.fail(in.pos, "unknown assignment operator %d", in.operands[0])
+		}
+	case code.OpReturn:
+		if f.index < 0 {
+			return f.fail(in.pos, "OpReturn outside a function")
+		}
+	}
+	return nil
+}
+
+// 跳转目标必须是指令的开头,主程序还可以跳到末尾结束运行
+func (f *verifiedFunction) checkTarget(in instruction, target int) error {
+	if _, ok := f.starts[target]; ok {
+		return nil
+	}
+	if target == len(f.fn.Instructions) && f.index < 0 {
+		return nil
+	}
+	return f.fail(in.pos, "jump target %d is not an instruction", target)
+}
+
+func (v *verifier) checkFree(f *verifiedFunction) error {
+	for _, in := range f.instructions {
+		switch in.op {
+		case code.OpGetFree, code.OpGetFreeCell, code.OpSetFreeCell:
+			if in.operands[0] >= f.numFree {
+				return f.fail(in.pos, "free variable index %d out of range", in.operands[0])
+			}
+		}
+	}
+	return nil
+}
+
+// 指令弹出和压入的元素个数
+func stackEffect(in instruction) (pop, push int) {
+	if binaryOps[in.op] {
+		return 2, 1
+	}
+	switch in.op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal, code.OpGetLocal,
+		code.OpGetBuiltin, code.OpGetFree, code.OpCurrentClosure, code.OpGetLocalCell, code.OpGetFreeCell,
+		code.OpBreakValue, code.OpContinueValue, code.OpGetGlobalCell:
+		return 0, 1
+	case code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal, code.OpSetGlobalCell, code.OpSetLocal, code.OpSetLocalCell,
+		code.OpSetFreeCell, code.OpReturnValue:
+		return 1, 0
+	case code.OpMinus, code.OpBang, code.OpIter, code.OpMatchArray, code.OpMatchHash, code.OpMember:
+		return 1, 1
+	case code.OpIndex, code.OpMatchKey, code.OpMatchLiteral:
+		return 2, 1
+	case code.OpSetIndex:
+		return 3, 1
+	case code.OpArray, code.OpHash, code.OpTemplate:
+		return in.operands[0], 1
+	case code.OpModule, code.OpClosure:
+		return in.operands[1], 1
+	case code.OpCall:
+		return in.operands[0] + 1, 1
+	case code.OpIterNext:
+		// 迭代器留在栈上,继续迭代时压入下标(键)和元素(值)
+		return 1, 3
+	}
+	return 0, 0
+}
+
+// 沿着所有执行路径计算栈的高度,拒绝弹空栈或在汇合处高度不同的指令序列
+// 函数的栈从局部绑定之上开始,高度都从0开始计算
+func (v *verifier) checkStack(f *verifiedFunction) error {
+	heights := make([]int, len(f.instructions))
+	for i := range heights {
+		heights[i] = -1
+	}
+
+	var worklist []int
+	enter := func(from instruction, pos, height int) error {
+		i, ok := f.starts[pos]
+		if !ok {
+			// 执行到指令末尾,checkTarget已经保证只有主程序会跳到末尾
+			if f.index >= 0 {
+				return f.fail(from.pos, "missing return at end of function")
+			}
+			return nil
+		}
+		if heights[i] == -1 {
+			heights[i] = height
+			worklist = append(worklist, i)
+		} else if heights[i] != height {
+			return f.fail(pos, "inconsistent stack height %d and %d", heights[i], height)
+		}
+		return nil
+	}
+
+	if len(f.instructions) == 0 {
+		if f.index >= 0 {
+			return f.fail(0, "missing return at end of function")
+		}
+		return nil
+	}
+	heights[0] = 0
+	worklist = append(worklist, 0)
+
+	for len(worklist) > 0 {
+		i := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		in := f.instructions[i]
+
+		pop, push := stackEffect(in)
+		if heights[i] < pop {
+			return f.fail(in.pos, "stack underflow in %s", in.name)
+		}
+		height := heights[i] - pop + push
+		next := len(f.fn.Instructions)
+		if i+1 < len(f.instructions) {
+			next = f.instructions[i+1].pos
+		}
+
+		var err error
+		switch in.op {
+		case code.OpReturnValue, code.OpReturn:
+		case code.OpJump:
+			err = enter(in, in.operands[0], height)
+		case code.OpJumpNotTruthy:
+			if err = enter(in, in.operands[0], height); err == nil {
+				err = enter(in, next, height)
+			}
+		case code.OpIterNext:
+			// 迭代结束时跳转,栈上只剩迭代器
+			if err = enter(in, in.operands[0], heights[i]); err == nil {
+				err = enter(in, next, height)
+			}
+		case code.OpGetModule:
+			// 模块已经运行时压入模块并跳转
+			if err = enter(in, in.operands[1], height+1); err == nil {
+				err = enter(in, next, height)
+			}
+		default:
+			err = enter(in, next, height)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
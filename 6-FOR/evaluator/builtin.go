@@ -4,6 +4,11 @@ package evaluator
 import (
 	"fmt"
 	"malang/object"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 var builtins = map[string]*object.Builtin{
@@ -23,6 +28,19 @@ var builtins = map[string]*object.Builtin{
 			}
 		},
 	},
+	// 传入字符串,按字符(rune)计算长度,len则按字节计算
+	"rune_len": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			if args[0].Type() != object.STRING_OBJ {
+				return newError("argument to `rune_len` must be STRING. got %s", args[0].Type())
+			}
+			str := args[0].(*object.String)
+			return &object.Integer{Value: int64(utf8.RuneCountInString(str.Value))}
+		},
+	},
 	// 输出内容
 	"puts": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
@@ -103,5 +121,269 @@ var builtins = map[string]*object.Builtin{
 			return &object.Array{Elements: newElements}
 		},
 	},
+	// 向数组末尾添加一个或多个元素,原地修改并返回该数组
+	"append!": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 2 {
+				return newError("wrong number of arguments. got=%d, want>=2", len(args))
+			}
+			if args[0].Type() != object.ARRAY_OBJ {
+				return newError("argument to `append!` must be ARRAY. got %s", args[0].Type())
+			}
+			arr := args[0].(*object.Array)
+			arr.Elements = append(arr.Elements, args[1:]...)
+			return arr
+		},
+	},
+	// 删除并返回数组的最后一个元素(原地修改)
+	"pop": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			if args[0].Type() != object.ARRAY_OBJ {
+				return newError("argument to `pop` must be ARRAY. got %s", args[0].Type())
+			}
+			arr := args[0].(*object.Array)
+			length := len(arr.Elements)
+			if length == 0 {
+				return newError("pop from empty array")
+			}
+			last := arr.Elements[length-1]
+			arr.Elements[length-1] = nil
+			arr.Elements = arr.Elements[:length-1]
+			return last
+		},
+	},
+	// 在数组下标i处插入元素(0 <= i <= len),原地修改并返回该数组
+	"insert": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=3", len(args))
+			}
+			if args[0].Type() != object.ARRAY_OBJ {
+				return newError("argument to `insert` must be ARRAY. got %s", args[0].Type())
+			}
+			idx, ok := args[1].(*object.Integer)
+			if !ok {
+				return newError("second argument to `insert` must be INTEGER. got %s", args[1].Type())
+			}
+			arr := args[0].(*object.Array)
+			length := int64(len(arr.Elements))
+			if idx.Value < 0 || idx.Value > length {
+				return newError("index out of range: %d (len %d)", idx.Value, length)
+			}
+			arr.Elements = append(arr.Elements, nil)
+			copy(arr.Elements[idx.Value+1:], arr.Elements[idx.Value:])
+			arr.Elements[idx.Value] = args[2]
+			return arr
+		},
+	},
+	// 删除并返回数组下标i处的元素(原地修改)
+	"remove": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			if args[0].Type() != object.ARRAY_OBJ {
+				return newError("argument to `remove` must be ARRAY. got %s", args[0].Type())
+			}
+			idx, ok := args[1].(*object.Integer)
+			if !ok {
+				return newError("second argument to `remove` must be INTEGER. got %s", args[1].Type())
+			}
+			arr := args[0].(*object.Array)
+			length := int64(len(arr.Elements))
+			if idx.Value < 0 || idx.Value >= length {
+				return newError("index out of range: %d (len %d)", idx.Value, length)
+			}
+			removed := arr.Elements[idx.Value]
+			copy(arr.Elements[idx.Value:], arr.Elements[idx.Value+1:])
+			arr.Elements[length-1] = nil
+			arr.Elements = arr.Elements[:length-1]
+			return removed
+		},
+	},
+	// 设置哈希表的键值对,原地修改并返回该哈希表
+	"set": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=3", len(args))
+			}
+			if args[0].Type() != object.HASH_OBJ {
+				return newError("argument to `set` must be HASH. got %s", args[0].Type())
+			}
+			key, ok := args[1].(object.Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+			hash := args[0].(*object.Hash)
+			hash.Pairs[key.HashKey()] = object.HashPair{Key: args[1], Value: args[2]}
+			return hash
+		},
+	},
+	// 删除哈希表中的键,返回被删除的值(原地修改)
+	"delete": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			if args[0].Type() != object.HASH_OBJ {
+				return newError("argument to `delete` must be HASH. got %s", args[0].Type())
+			}
+			key, ok := args[1].(object.Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+			hash := args[0].(*object.Hash)
+			pair, ok := hash.Pairs[key.HashKey()]
+			if !ok {
+				return newError("key not found: %s", args[1].Inspect())
+			}
+			delete(hash.Pairs, key.HashKey())
+			return pair.Value
+		},
+	},
+	// 生成整数数组: range(end), range(start, end), range(start, end, step)
+	"range": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=1..3", len(args))
+			}
+			bounds := []int64{0, 0, 1}
+			for i, arg := range args {
+				n, ok := arg.(*object.Integer)
+				if !ok {
+					return newError("arguments to `range` must be INTEGER. got %s", arg.Type())
+				}
+				bounds[i] = n.Value
+			}
+			start, end, step := bounds[0], bounds[1], bounds[2]
+			if len(args) == 1 {
+				start, end = 0, bounds[0]
+			}
+			if step == 0 {
+				return newError("range step cannot be zero")
+			}
+
+			elements := []object.Object{}
+			for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+				elements = append(elements, &object.Integer{Value: i})
+				// 下一个值超出整数范围时结束,否则会回绕成负数(正数)永远不停
+				if (step > 0 && i > math.MaxInt64-step) || (step < 0 && i < math.MinInt64-step) {
+					break
+				}
+			}
+			return &object.Array{Elements: elements}
+		},
+	},
+	// 生成唯一的标识符,返回quote: gensym() 或 gensym("tmp")
+	"gensym": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+			}
+			if len(args) == 0 {
+				return gensym("g")
+			}
+			prefix, ok := args[0].(*object.String)
+			if !ok {
+				return newError("argument to `gensym` must be STRING. got %s", args[0].Type())
+			}
+			return gensym(prefix.Value)
+		},
+	},
+	// 转为整数: 浮点数向零截断,字符串按十进制解析
+	"int": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *object.Integer:
+				return arg
+			case *object.Float:
+				if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+					return newError("cannot convert %s to INTEGER", arg.Inspect())
+				}
+				return &object.Integer{Value: int64(arg.Value)}
+			case *object.String:
+				value, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 10, 64)
+				if err != nil {
+					return newError("cannot convert %q to INTEGER", arg.Value)
+				}
+				return &object.Integer{Value: value}
+			default:
+				return newError("argument to `int` not supported. got %s", args[0].Type())
+			}
+		},
+	},
+	// 转为浮点数
+	"float": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *object.Integer:
+				return &object.Float{Value: float64(arg.Value)}
+			case *object.Float:
+				return arg
+			case *object.String:
+				value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					return newError("cannot convert %q to FLOAT", arg.Value)
+				}
+				return &object.Float{Value: value}
+			default:
+				return newError("argument to `float` not supported. got %s", args[0].Type())
+			}
+		},
+	},
+	// 四舍五入: round(x)返回整数, round(x, n)保留n位小数返回浮点数
+	"round": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			if !isNumber(args[0]) {
+				return newError("argument to `round` must be INTEGER or FLOAT. got %s", args[0].Type())
+			}
+			value := toFloat(args[0])
+			if len(args) == 1 {
+				if args[0].Type() == object.INTEGER_OBJ {
+					return args[0]
+				}
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					return newError("cannot convert %s to INTEGER", args[0].Inspect())
+				}
+				return &object.Integer{Value: int64(math.Round(value))}
+			}
+			digits, ok := args[1].(*object.Integer)
+			if !ok {
+				return newError("second argument to `round` must be INTEGER. got %s", args[1].Type())
+			}
+			scale := math.Pow(10, float64(digits.Value))
+			return &object.Float{Value: math.Round(value*scale) / scale}
+		},
+	},
 	// todo: 文件读写 网络编程 数据库(用原生的"database/sql") 
 }
+
+// 按名字排序的内置函数名,编译器和虚拟机用其中的下标引用内置函数
+var BuiltinNames = sortedBuiltinNames()
+
+func sortedBuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 按名字查找内置函数
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
@@ -2,16 +2,20 @@
 package evaluator
 
 import (
+	"bytes"
 	"fmt"
 	"malang/ast"
 	"malang/object"
-	"malang/util"
+	"math"
+	"strings"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = &object.Null{}
+	TRUE     = &object.Boolean{Value: true}
+	FALSE    = &object.Boolean{Value: false}
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 // 求布尔型的值
@@ -24,12 +28,14 @@ func nativeBooleanObject(input bool) *object.Boolean {
 
 // -操作符求值(前缀)
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 // !操作符求值
@@ -47,14 +53,14 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 // 解析前缀 ! -
-func evalPrefixExpression(operator string, right object.Object) object.Object {
+func EvalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
 	default:
-		return newError("unknown operator: %s%s"+operator, right.Type())
+		return newError("unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -71,11 +77,43 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero: %d / %d", leftVal, rightVal)
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero: %d %% %d", leftVal, rightVal)
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "**":
+		// 负指数的结果是小数
+		if rightVal < 0 {
+			return &object.Float{Value: math.Pow(float64(leftVal), float64(rightVal))}
+		}
+		return &object.Integer{Value: integerPow(leftVal, rightVal)}
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<", ">>":
+		if rightVal < 0 {
+			return newError("negative shift count: %d %s %d", leftVal, operator, rightVal)
+		}
+		if operator == "<<" {
+			return &object.Integer{Value: leftVal << uint64(rightVal)}
+		}
+		return &object.Integer{Value: leftVal >> uint64(rightVal)}
 	case "<":
 		return nativeBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -85,33 +123,109 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	}
 }
 
-// 解析字符串中缀操作
-func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
+// 整数乘方(快速幂)
+func integerPow(base, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+// 是否是数字(整数或浮点数)
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// 数字转为浮点数(整数会被提升)
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	}
+	return 0
+}
+
+// 解析两侧有浮点数的中缀表达式,整数会被提升为浮点数(eg. 1 + 2.5)
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
+	case "<":
+		return nativeBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBooleanObject(leftVal != rightVal)
+	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
+}
+
+// 解析字符串中缀操作(拼接和按字典序比较)
+func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
 
-// 解析中缀表达式
-func evalInfixExpression(operator string, left, right object.Object) object.Object {
+// 解析中缀表达式,虚拟机也使用这些运算函数,保证两种后端的语义一致
+func EvalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(operator, left, right)
 	// 这里可以直接对比是因为布尔型都是复用true和false两个对象的指针(地址),可以直接比对地址来看是否相等
 	case operator == "==":
 		return nativeBooleanObject(left == right)
 	case operator == "!=":
 		return nativeBooleanObject(left != right)
-	// todo: && 和 ||
-	case operator == "&&":
-		return nativeBooleanObject(left.(*object.Boolean).Value && right.(*object.Boolean).Value)
-	case operator == "||":
-		return nativeBooleanObject(left.(*object.Boolean).Value || right.(*object.Boolean).Value)
-	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
@@ -120,7 +234,7 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 }
 
 // if判真策略(真值[true,!false,!null]为成立)
-func isTruthy(obj object.Object) bool {
+func IsTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
 		return false
@@ -133,6 +247,133 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
+// 解析 && 和 || (短路求值,按IsTruthy判断真假)
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	// 左侧已经可以决定结果时,不再对右侧求值
+	if node.Operator == "&&" && !IsTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && IsTruthy(left) {
+		return TRUE
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+	return nativeBooleanObject(IsTruthy(right))
+}
+
+// 赋值表达式求值
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		return evalIdentifierAssign(node, target, env)
+	case *ast.IndexExpression:
+		return evalIndexAssign(node, target, env)
+	default:
+		return newError("invalid assignment target: %s", node.Target)
+	}
+}
+
+// 复合赋值 x += 1 相当于 x = x + 1
+// 空的块没有值(nil),和null一样参与运算和赋值
+func evalCompoundAssign(operator string, current, val object.Object) object.Object {
+	if val == nil {
+		val = NULL
+	}
+	if current == nil {
+		current = NULL
+	}
+	if operator == "=" {
+		return val
+	}
+	return EvalInfixExpression(strings.TrimSuffix(operator, "="), current, val)
+}
+
+// 对变量赋值,更新定义该变量的(外层)环境
+func evalIdentifierAssign(node *ast.AssignExpression, ident *ast.Identifier, env *object.Environment) object.Object {
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	current, ok := env.Get(ident.Value)
+	if !ok {
+		return newError("assignment to undeclared variable: %s", ident.Value)
+	}
+	val = evalCompoundAssign(node.Operator, current, val)
+	if isError(val) {
+		return val
+	}
+
+	env.Assign(ident.Value, val)
+	return val
+}
+
+// 对数组元素或哈希表的键赋值(原地修改)
+func evalIndexAssign(node *ast.AssignExpression, target *ast.IndexExpression, env *object.Environment) object.Object {
+	left := Eval(target.Left, env)
+	if isError(left) {
+		return left
+	}
+	index := Eval(target.Index, env)
+	if isError(index) {
+		return index
+	}
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+	return AssignIndex(node.Operator, left, index, val)
+}
+
+// 执行索引赋值,虚拟机也使用这个函数
+func AssignIndex(operator string, left, index, val object.Object) object.Object {
+	if val == nil {
+		val = NULL
+	}
+	switch left := left.(type) {
+	case *object.Array:
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INTEGER. got %s", index.Type())
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d (len %d)", idx.Value, len(left.Elements))
+		}
+		val = evalCompoundAssign(operator, left.Elements[idx.Value], val)
+		if isError(val) {
+			return val
+		}
+		left.Elements[idx.Value] = val
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		if operator != "=" {
+			pair, ok := left.Pairs[key.HashKey()]
+			if !ok {
+				return newError("key not found: %s", index.Inspect())
+			}
+			val = evalCompoundAssign(operator, pair.Value, val)
+			if isError(val) {
+				return val
+			}
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+
+	return val
+}
+
 // 解析If表达式
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
@@ -140,13 +381,126 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 		return condition
 	}
 
-	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+	var result object.Object
+	if IsTruthy(condition) {
+		result = Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
-	} else {
+		result = Eval(ie.Alternative, env)
+	}
+	// 没有执行分支或分支为空时返回null
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+// 解析match表达式,依次尝试每个分支,没有匹配的分支时返回null
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(me.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for _, arm := range me.Arms {
+		// 模式中绑定的变量只在当前分支内可见
+		armEnv := object.NewEnclosedEnvironment(env)
+		matched, err := matchPattern(arm.Pattern, subject, armEnv)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+			if !IsTruthy(guard) {
+				continue
+			}
+		}
+		// 分支体为空时返回null
+		if result := Eval(arm.Body, armEnv); result != nil {
+			return result
+		}
 		return NULL
 	}
+	return NULL
+}
+
+// 判断值是否匹配模式,匹配时将绑定的变量写入env
+func matchPattern(pattern ast.Expression, value object.Object, env *object.Environment) (bool, *object.Error) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		// _ 匹配任意值,不绑定
+		if pattern.Value != "_" {
+			env.Set(pattern.Value, value)
+		}
+		return true, nil
+	case *ast.ArrayLiteral:
+		arr, ok := value.(*object.Array)
+		if !ok || len(arr.Elements) != len(pattern.Elements) {
+			return false, nil
+		}
+		for i, el := range pattern.Elements {
+			if matched, err := matchPattern(el, arr.Elements[i], env); !matched || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case *ast.HashLiteral:
+		hash, ok := value.(*object.Hash)
+		if !ok {
+			return false, nil
+		}
+		// 只要求模式中的键都存在,允许多余的键
+		for keyNode, valueNode := range pattern.Pairs {
+			key := Eval(keyNode, env)
+			if isError(key) {
+				return false, key.(*object.Error)
+			}
+			pair, ok := hash.Pairs[key.(object.Hashable).HashKey()]
+			if !ok {
+				return false, nil
+			}
+			if matched, err := matchPattern(valueNode, pair.Value, env); !matched || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	default:
+		// 字面量
+		expected := Eval(pattern, env)
+		if err, ok := expected.(*object.Error); ok {
+			return false, err
+		}
+		return LiteralEqual(expected, value), nil
+	}
+}
+
+// 字面量模式的比较,整数和浮点数按数值比较,其他类型需要类型和值都相同
+func LiteralEqual(expected, value object.Object) bool {
+	if a, ok := expected.(*object.Integer); ok {
+		if b, ok := value.(*object.Integer); ok {
+			return a.Value == b.Value
+		}
+	}
+	if isNumber(expected) && isNumber(value) {
+		return toFloat(expected) == toFloat(value)
+	}
+	if expected.Type() != value.Type() {
+		return false
+	}
+	switch expected := expected.(type) {
+	case *object.String:
+		return expected.Value == value.(*object.String).Value
+	case *object.Boolean:
+		return expected == value
+	case *object.Null:
+		return true
+	}
+	return false
 }
 
 // 解析program
@@ -161,6 +515,10 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 			return result.Value
 		case *object.Error:
 			return result
+		case *object.Break, *object.Continue:
+			err := newError("%s outside of loop", result.Inspect())
+			err.Pos = statement.Pos()
+			return err
 		}
 	}
 
@@ -176,7 +534,9 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			// break和continue也需要冒泡,直到最近的循环
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ ||
+				rt == object.BREAK || rt == object.CONTINUE {
 				return result
 			}
 		}
@@ -184,6 +544,101 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
+// 解析for循环
+func evalForExpression(fe *ast.ForExpression, env *object.Environment) object.Object {
+	for {
+		condition := Eval(fe.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !IsTruthy(condition) {
+			break
+		}
+
+		result := Eval(fe.Body, env)
+		if result == nil {
+			continue
+		}
+		switch result.Type() {
+		case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+			return result
+		case object.BREAK:
+			return NULL
+		}
+		// continue: 直接进入下一次条件判断
+	}
+	return NULL
+}
+
+// 解析范围循环
+// 数组和字符串: (下标, 元素); 哈希表: (键, 值),只写一个变量时为键; 整数n: 0到n-1
+func evalForRangeExpression(fr *ast.ForRangeExpression, env *object.Environment) object.Object {
+	iterable := Eval(fr.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	var result object.Object = NULL
+	// 执行一次循环体,返回false时结束循环
+	step := func(key, value object.Object) bool {
+		// 每次迭代使用新的环境,闭包捕获的是当次的值
+		loopEnv := object.NewEnclosedEnvironment(env)
+		if fr.Key != nil {
+			loopEnv.Set(fr.Key.Value, key)
+		}
+		loopEnv.Set(fr.Value.Value, value)
+
+		res := Eval(fr.Body, loopEnv)
+		if res == nil {
+			return true
+		}
+		switch res.Type() {
+		case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+			result = res
+			return false
+		case object.BREAK:
+			return false
+		}
+		return true
+	}
+
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		for i, el := range iterable.Elements {
+			if !step(&object.Integer{Value: int64(i)}, el) {
+				break
+			}
+		}
+	case *object.Hash:
+		for _, pair := range iterable.SortedPairs() {
+			value := pair.Value
+			if fr.Key == nil {
+				value = pair.Key
+			}
+			if !step(pair.Key, value) {
+				break
+			}
+		}
+	case *object.String:
+		i := int64(0)
+		for _, ch := range iterable.Value {
+			if !step(&object.Integer{Value: i}, &object.String{Value: string(ch)}) {
+				break
+			}
+			i++
+		}
+	case *object.Integer:
+		for i := int64(0); i < iterable.Value; i++ {
+			if !step(&object.Integer{Value: i}, &object.Integer{Value: i}) {
+				break
+			}
+		}
+	default:
+		return newError("cannot range over %s", iterable.Type())
+	}
+	return result
+}
+
 // 对标识符求值
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	if val, ok := env.Get(node.Value); ok {
@@ -233,13 +688,24 @@ func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Enviro
 func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
 		extendedEnv := extendFunctionEnv(fn, args)
 		evaluated := Eval(fn.Body, extendedEnv)
+		// break和continue不能穿过函数边界
+		switch evaluated.(type) {
+		case *object.Break, *object.Continue:
+			return newError("%s outside of loop", evaluated.Inspect())
+		}
+		// 函数体为空时返回null
+		if evaluated == nil {
+			return NULL
+		}
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
 		return fn.Fn(args...)
 	default:
-		fmt.Println(fn)
 		return newError("not a function: %s", fn.Type())
 	}
 }
@@ -273,7 +739,7 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 }
 
 // 索引表达式求值
-func evalIndexExpression(left, index object.Object) object.Object {
+func EvalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
@@ -285,6 +751,32 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	}
 }
 
+// 插值字符串求值,在当前环境中对${}中的表达式求值并拼接
+func evalTemplateLiteral(node *ast.TemplateLiteral, env *object.Environment) object.Object {
+	var out bytes.Buffer
+
+	for _, part := range node.Parts {
+		val := Eval(part, env)
+		if isError(val) {
+			return val
+		}
+		out.WriteString(TemplatePart(val))
+	}
+
+	return &object.String{Value: out.String()}
+}
+
+// 插值片段转换为字符串,字符串不带引号,其他值使用Inspect
+func TemplatePart(val object.Object) string {
+	if str, ok := val.(*object.String); ok {
+		return str.Value
+	}
+	if val != nil {
+		return val.Inspect()
+	}
+	return ""
+}
+
 // 哈希表求值
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair)
@@ -312,7 +804,14 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 	return &object.Hash{Pairs: pairs}
 }
 
-func Eval(node ast.Node, env *object.Environment) object.Object {
+func Eval(node ast.Node, env *object.Environment) (result object.Object) {
+	// 错误冒泡时,由最内层产生错误的节点补充位置信息
+	defer func() {
+		if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() && node != nil {
+			err.Pos = node.Pos()
+		}
+	}()
+
 	switch node := node.(type) {
 	// 语句 -> 继续遍历
 	// 根节点
@@ -320,8 +819,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalProgram(node, env)
 	// use导入语句
 	case *ast.UseExpression:
-		// 解析
-		return Eval(util.LoadMalFile(node.FileName), env)
+		return evalUseExpression(node, env)
+	// 成员访问
+	case *ast.MemberExpression:
+		return evalMemberExpression(node, env)
 	// 块语句
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
@@ -331,11 +832,18 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(val) {
 			return val
 		}
+		// 空的块没有值,绑定为null
+		if val == nil {
+			val = NULL
+		}
 		// 关联标识符和值
 		env.Set(node.Name.Value, val)
 	// 标识符
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
+	// 赋值
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	// 函数语句
 	case *ast.FunctionLiteral:
 		params := node.Parameters
@@ -343,8 +851,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return &object.Function{Parameters: params, Body: body, Env: env}
 	// 调用函数
 	case *ast.CallExpression:
-		if node.Function.TokenLiteral() == "quote" {
+		switch node.Function.TokenLiteral() {
+		case "quote":
+			if len(node.Arguments) != 1 {
+				return newError("wrong number of arguments to `quote`. got=%d, want=1", len(node.Arguments))
+			}
 			return quote(node.Arguments[0], env)
+		case "macroexpand", "macroexpand1":
+			return evalMacroExpand(node, env)
 		}
 		function := Eval(node.Function, env)
 		if isError(function) {
@@ -368,6 +882,18 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	// IF语句
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	// match表达式
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
+	// for循环
+	case *ast.ForExpression:
+		return evalForExpression(node, env)
+	case *ast.ForRangeExpression:
+		return evalForRangeExpression(node, env)
+	case *ast.BreakExpression:
+		return BREAK
+	case *ast.ContinueExpression:
+		return CONTINUE
 	// 索引表达式
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
@@ -378,19 +904,28 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(index) {
 			return index
 		}
-		return evalIndexExpression(left, index)
+		return EvalIndexExpression(left, index)
 	// 表达式语句
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
 	// 表达式 -> 求值
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	// 浮点数
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	// 布尔型
 	case *ast.Boolean:
 		return nativeBooleanObject(node.Value)
+	// 空值
+	case *ast.NullLiteral:
+		return NULL
 	// 字符串
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	// 插值字符串
+	case *ast.TemplateLiteral:
+		return evalTemplateLiteral(node, env)
 	// 数组
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
@@ -409,9 +944,12 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return EvalPrefixExpression(node.Operator, right)
 		// 中缀表达式
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
 		left := Eval(node.Left, env)
 		// 判断该中断是不是Error引发的
 		if isError(left) {
@@ -422,7 +960,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return EvalInfixExpression(node.Operator, left, right)
 	}
 	return nil
 }
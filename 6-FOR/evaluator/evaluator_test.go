@@ -27,12 +27,64 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"3 * 2 ** 2", 12},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 4", 16},
+		{"256 >> 2", 64},
+		{"1 | 2 ^ 6 & 3", 1},
 	}
 	for _, tt := range ts {
 		eval := testEval(tt.input)
 		testIntegerObject(t, eval, tt.expected)
 	}
 }
+func TestEvalFloatExpression(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14", 3.14},
+		{".5", 0.5},
+		{"1e-9", 1e-9},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"7 / 2.0", 3.5},
+		{"2 * 1.25 - 1", 1.5},
+		{"float(3)", 3.0},
+		{`float("2.75")`, 2.75},
+		{"round(3.14159, 2)", 3.14},
+		{"round(2.5, 0)", 3.0},
+		{"7.5 % 2", 1.5},
+		{"2 ** 0.5 * 2 ** 0.5", 2.0000000000000004},
+		{"2 ** -1", 0.5},
+		{"9.0 ** 0.5", 3.0},
+	}
+	for _, tt := range ts {
+		eval := testEval(tt.input)
+		testFloatObject(t, eval, tt.expected)
+	}
+}
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	res, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("obj is not float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if res.Value != expected {
+		t.Errorf("obj has wrong val. want=%g, got=%g", expected, res.Value)
+		return false
+	}
+	return true
+}
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -67,10 +119,59 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"1 != 1", false},
 		{"1 == 2", false},
 		{"1 != 2", true},
-		{"(1 < 2) == ture", true},
+		{"(1 < 2) == true", true},
 		{"(1 < 2) == false", false},
-		{"(1 > 2) == ture", false},
+		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"0.1 + 0.2 == 0.3", false},
+		{"2.5 != 2.5", false},
+		{"1 / 0.0 > 1e300", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"2 >= 2", true},
+		{"1 >= 2", false},
+		{"1.5 <= 1.5", true},
+		{"2 >= 2.5", false},
+		{`"a" < "b"`, true},
+		{`"abc" < "abd"`, true},
+		{`"b" > "abc"`, true},
+		{`"abc" <= "abc"`, true},
+		{`"ab" >= "abc"`, false},
+		{`"abc" == "abc"`, true},
+		{`"abc" != "abc"`, false},
+		{`"a" + "bc" == "abc"`, true},
+	}
+	for _, tt := range ts {
+		eval := testEval(tt.input)
+		testBooleanObject(t, eval, tt.expected)
+	}
+}
+func TestLogicalExpression(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected bool
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"1 && 2", true},
+		{`"" && [1]`, true},
+		{"if (false) { 1 } && true", false},
+		{`let x = "abc"; x && len(x) > 0`, true},
+		{"let x = if (false) { 1 }; x && len(x) > 0", false},
+		{"let x = if (false) { 1 }; x || 5", true},
+		{"false && foobar", false},
+		{"true || foobar", true},
+		{"false && 1 + true", false},
+		{"true || 1 / 0", true},
+		{"true && false || true", true},
 	}
 	for _, tt := range ts {
 		eval := testEval(tt.input)
@@ -106,6 +207,18 @@ func TestBangOperator(t *testing.T) {
 		testBooleanObject(t, eval, tt.expected)
 	}
 }
+
+// 语法分析只产生!和-,其他前缀运算符直接调用时报错
+func TestUnknownPrefixOperator(t *testing.T) {
+	err, ok := EvalPrefixExpression("~", &object.Integer{Value: 1}).(*object.Error)
+	if !ok {
+		t.Fatalf("expected error for ~1")
+	}
+	if err.Message != "unknown operator: ~INTEGER" {
+		t.Errorf("wrong error message. want=%q, got=%q", "unknown operator: ~INTEGER", err.Message)
+	}
+}
+
 func testNullObject(t *testing.T, obj object.Object) bool {
 	if obj != NULL {
 		t.Errorf("obj is not null. got=%T (%+v)", obj, obj)
@@ -125,6 +238,12 @@ func TestIfElseExpression(t *testing.T) {
 		{"if (1>2){10}", nil},
 		{"if (1>2){10} else {20}", 20},
 		{"if (1<2){10} else {20}", 10},
+		{"if (1>2){10} else if (2>1) {20} else {30}", 20},
+		{"if (1>2){10} else if (2>3) {20} else {30}", 30},
+		{"if (1>2){10} else if (2>3) {20}", nil},
+		{"let x = 3; if (x == 1) {10} else if (x == 2) {20} else if (x == 3) {30} else {40}", 30},
+		{"if (true) {}", nil},
+		{"if (false) { 1 } else { let a = 2; }", nil},
 	}
 	for _, tt := range ts {
 		eval := testEval(tt.input)
@@ -136,6 +255,58 @@ func TestIfElseExpression(t *testing.T) {
 		}
 	}
 }
+func TestMatchExpression(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`match (1) { 1 => "one", 2 => "two" }`, "one"},
+		{`match (2) { 1 => "one", 2 => "two" }`, "two"},
+		{`match (3) { 1 => "one", 2 => "two" }`, nil},
+		{`match (3) { 1 => "one", _ => "other" }`, "other"},
+		{`match (-1) { -1 => "neg", _ => "other" }`, "neg"},
+		{`match (1.0) { 1 => "one", _ => "other" }`, "one"},
+		{`match ("1") { 1 => "int", "1" => "str" }`, "str"},
+		{`match (true) { false => "f", true => "t" }`, "t"},
+		{`match (null) { 0 => "zero", null => "null" }`, "null"},
+		{`match ([1, null]) { [a, null] => a }`, 1},
+		{`match (5) { n => n * 2 }`, 10},
+		{`match (5) { n if n > 10 => "big", n if n > 3 => "mid", _ => "small" }`, "mid"},
+		{`match ([1, 2]) { [a] => a, [a, b] => a + b, _ => 0 }`, 3},
+		{`match ([1, [2, 3]]) { [1, [_, c]] => c }`, 3},
+		{`match ([1, 2]) { [2, b] => b, [1, b] => b * 10 }`, 20},
+		{`match ({"name": "mal", "age": 3}) { {"age": a} if a > 5 => "old", {"name": n} => n }`, "mal"},
+		{`match ({"k": 1}) { {"x": x} => x, _ => "none" }`, "none"},
+		{`match (1) { 1 => { let a = 2; a * 3 } }`, 6},
+		{`let n = 1; match (2) { n => n }; n;`, 1},
+		{`let f = fn(x) { match (x) { 0 => { return "zero"; }, _ => "other" }; "after" }; f(0);`, "zero"},
+		{`match (1 + true) { _ => 1 }`, "type mismatch: INTEGER + BOOLEAN"},
+		{`match (1) { n if n + true => 1 }`, "type mismatch: INTEGER + BOOLEAN"},
+		{`match (1) { 1 => {} }`, nil},
+		{`match (1) { 1 => { let a = 2; } }`, nil},
+		{`first([match (1) { 1 => {} }])`, nil},
+	}
+	for _, tt := range ts {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			var got string
+			switch obj := evaluated.(type) {
+			case *object.String:
+				got = obj.Value
+			case *object.Error:
+				got = obj.Message
+			}
+			if got != expected {
+				t.Errorf("wrong result for %s. expected=%q, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
 func TestReturnStatements(t *testing.T) {
 	ts := []struct {
 		input    string
@@ -171,10 +342,26 @@ func TestErrorHandling(t *testing.T) {
 		{"true + false;", "unknown operator: BOOLEAN + BOOLEAN"},
 		{"5; true + false; 5;", "unknown operator: BOOLEAN + BOOLEAN"},
 		{"if (10 > 1) {true+false;}", "unknown operator: BOOLEAN + BOOLEAN"},
-		{"if (10 > 1) {{false+false;} return 1;}", "unknown operator: BOOLEAN + BOOLEAN"},
+		{"if (10 > 1) { if (10 > 1) { return false+false; } return 1;}", "unknown operator: BOOLEAN + BOOLEAN"},
 		{"foobar;", "identifier not found: foobar"},
-		{`"hello" - "world`, "unknown operator: STRING - STRING"},
+		{`"hello" - "world"`, "unknown operator: STRING - STRING"},
 		{`{"name": "Monkey"}[fn(x) {x}];`, "unusable as hash key: FUNCTION"},
+		{"1.5 + true", "type mismatch: FLOAT + BOOLEAN"},
+		{"-true + 1.5", "unknown operator: -BOOLEAN"},
+		{"1 / 0", "division by zero: 1 / 0"},
+		{"5 % 0", "division by zero: 5 % 0"},
+		{"1 << -1", "negative shift count: 1 << -1"},
+		{"true && foobar", "identifier not found: foobar"},
+		{"x = 1", "assignment to undeclared variable: x"},
+		{"let f = fn() { y += 1 }; f()", "assignment to undeclared variable: y"},
+		{"let a = 1; a += true", "type mismatch: INTEGER + BOOLEAN"},
+		{"let a = 1; a /= 0", "division by zero: 1 / 0"},
+		{"foobar || true", "identifier not found: foobar"},
+		{"1.5 & 1", "unknown operator: FLOAT & INTEGER"},
+		{`"a" * "b"`, "unknown operator: STRING * STRING"},
+		{`"a" < 1`, "type mismatch: STRING < INTEGER"},
+		{"fn(a) { a }()", "wrong number of arguments: want=1, got=0"},
+		{"fn() { 1 }(1, 2)", "wrong number of arguments: want=0, got=2"},
 	}
 	for _, tt := range ts {
 		eval := testEval(tt.input)
@@ -203,6 +390,138 @@ func TestLetStatement(t *testing.T) {
 		testIntegerObject(t, testEval(tt.input), tt.expected)
 	}
 }
+func TestAssignExpression(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 5; a = 10; a;", 10},
+		{"let a = 5; a = 10;", 10},
+		{"let a = 5; a += 2; a;", 7},
+		{"let a = 5; a -= 2; a;", 3},
+		{"let a = 5; a *= 2; a;", 10},
+		{"let a = 9; a /= 2; a;", 4},
+		{"let a = 1; let b = 2; a = b = 3; a + b;", 6},
+		{"let a = 1; let f = fn() { a = 5; }; f(); a;", 5},
+		{"let a = 1; let f = fn() { let a = 2; a = 5; }; f(); a;", 1},
+		{"let counter = fn() { let n = 0; fn() { n += 1; n } }; let c = counter(); c(); c(); c();", 3},
+		{"let i = 0; for (i < 5) { i += 1; }; i;", 5},
+		{"let sum = 0; let i = 0; for (i < 4) { i += 1; sum += i; }; sum;", 10},
+	}
+	for _, tt := range ts {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+func TestIndexAssignExpression(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = [1, 2, 3]; a[0] = 10; a[0];", 10},
+		{"let a = [1, 2, 3]; a[2] += 5; a[2];", 8},
+		{"let a = [1, 2, 3]; let b = a; b[1] = 7; a[1];", 7},
+		{"let a = [[1], [2]]; a[1][0] = 9; a[1][0];", 9},
+		{"let h = {\"a\": 1}; h[\"a\"] = 2; h[\"a\"];", 2},
+		{"let h = {}; h[\"b\"] = 3; h[\"b\"];", 3},
+		{"let h = {1: 2}; h[1] *= 5; h[1];", 10},
+		{"let a = [0, 0]; let f = fn(arr) { arr[0] = 1; }; f(a); a[0];", 1},
+		{"let a = [1]; a[1] = 2;", "index out of range: 1 (len 1)"},
+		{"let a = [1]; a[-1] = 2;", "index out of range: -1 (len 1)"},
+		{"let a = [1]; a[\"x\"] = 2;", "array index must be INTEGER. got STRING"},
+		{"let h = {}; h[fn(x) { x }] = 1;", "unusable as hash key: FUNCTION"},
+		{"let h = {}; h[\"k\"] += 1;", "key not found: k"},
+		{"let s = \"abc\"; s[0] = \"x\";", "index assignment not supported: STRING"},
+	}
+	for _, tt := range ts {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+// 空的块没有值,赋值和绑定时当作null
+func TestAssignEmptyBlock(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1; x += if (true) {};", "ERROR: 1:14: type mismatch: INTEGER + NULL"},
+		{"let x = 1; x = if (true) {}; x", "null"},
+		{"let a = [1]; a[0] = if (false) { 1 } else {}; a", "[null]"},
+		{"let a = [1]; a[0] += if (true) {}; a", "ERROR: 1:19: type mismatch: INTEGER + NULL"},
+		{`let h = {}; h["k"] = if (true) {}; h`, "{k: null}"},
+		{"let y = if (true) {}; y", "null"},
+	}
+	for _, tt := range ts {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q: got nil", tt.input)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestInPlaceBuiltins(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = [1]; append!(a, 2, 3); a;", "[1, 2, 3]"},
+		{"let a = [1, 2]; pop(a);", 2},
+		{"let a = [1, 2]; pop(a); a;", "[1]"},
+		{"let a = [1, 3]; insert(a, 1, 2); a;", "[1, 2, 3]"},
+		{"let a = [1]; insert(a, 1, 2); a;", "[1, 2]"},
+		{"let a = [1, 2, 3]; remove(a, 0);", 1},
+		{"let a = [1, 2, 3]; remove(a, 1); a;", "[1, 3]"},
+		{"let h = {}; set(h, \"a\", 1); h[\"a\"];", 1},
+		{"let h = {\"a\": 1}; delete(h, \"a\");", 1},
+		{"let h = {\"a\": 1}; delete(h, \"a\"); h;", "{}"},
+		{"pop([])", errorMessage("pop from empty array")},
+		{"insert([1], 2, 0)", errorMessage("index out of range: 2 (len 1)")},
+		{"remove([1], 1)", errorMessage("index out of range: 1 (len 1)")},
+		{"delete({}, \"a\")", errorMessage("key not found: a")},
+		{"append!(1, 2)", errorMessage("argument to `append!` must be ARRAY. got INTEGER")},
+		{"set([], 1, 2)", errorMessage("argument to `set` must be HASH. got ARRAY")},
+	}
+	for _, tt := range ts {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if evaluated.Inspect() != expected {
+				t.Errorf("wrong result. expected=%q, got=%q", expected, evaluated.Inspect())
+			}
+		case errorMessage:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != string(expected) {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+// 区分期望的错误信息和期望的字符串结果
+type errorMessage string
+
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2;};"
 	eval := testEval(input)
@@ -232,6 +551,7 @@ func TestFunctionApplication(t *testing.T) {
 		expected int64
 	}{
 		{"let identity=fn(x){x;} identity(5);", 5},
+		{"let double = fn(x) { x * 2 // 注释不是返回值\n}; double(2);", 4},
 		{"let identity=fn(x){return x;}; identity(5);", 5},
 		{"let double = fn(x){x*2;};double(5);", 10},
 		{"let add = fn(x,y){x+y;}; add(5,5);", 10},
@@ -253,6 +573,44 @@ func TestStringLiteral(t *testing.T) {
 		t.Errorf("string has wrong value. got=%q", str.Value)
 	}
 }
+func TestUnicodeIdentifiers(t *testing.T) {
+	input := `let 总数 = 1; let 名字 = "世界"; // 说明
+	"你好, " + 名字 + "!"`
+	eval := testEval(input)
+	str, ok := eval.(*object.String)
+	if !ok {
+		t.Fatalf("object is not string. got=%T(%+v)", eval, eval)
+	}
+	if str.Value != "你好, 世界!" {
+		t.Errorf("string has wrong value. got=%q", str.Value)
+	}
+}
+func TestStringEscapesAndInterpolation(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{`"tab\there\n"`, "tab\there\n"},
+		{`"say \"hi\""`, `say "hi"`},
+		{"`raw ${x} \\n`", `raw ${x} \n`},
+		{`let x = 3; "x = ${x}"`, "x = 3"},
+		{`let xs = [1, 2, 3]; "total: ${xs[0] + xs[1] + xs[2]}!"`, "total: 6!"},
+		{`let name = "世界"; "你好, ${name}"`, "你好, 世界"},
+		{`"${"a" + "b"}${[1, 2]}${true}"`, "ab[1, 2]true"},
+		{`let f = fn(n) { "n=${n}" }; f(7)`, "n=7"},
+	}
+	for _, tt := range ts {
+		eval := testEval(tt.input)
+		str, ok := eval.(*object.String)
+		if !ok {
+			t.Errorf("object is not string. got=%T(%+v)", eval, eval)
+			continue
+		}
+		if str.Value != tt.expected {
+			t.Errorf("string has wrong value. want=%q, got=%q", tt.expected, str.Value)
+		}
+	}
+}
 func TestStringConcatenation(t *testing.T) {
 	input := `"hello" +" " +"world"`
 	eval := testEval(input)
@@ -274,6 +632,20 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("hello world")`, 11},
 		{`len(1)`, "argument to `len` not supported. got INTEGER"},
 		{`len("one","two")`, "wrong number of arguments. got=2, want=1"},
+		{`len("你好")`, 6},
+		{`rune_len("你好")`, 2},
+		{`rune_len("hello 世界")`, 8},
+		{`rune_len(1)`, "argument to `rune_len` must be STRING. got INTEGER"},
+		{`int(3.99)`, 3},
+		{`int(-3.99)`, -3},
+		{`int("42")`, 42},
+		{`int(7)`, 7},
+		{`int("4.2")`, `cannot convert "4.2" to INTEGER`},
+		{`round(2.5)`, 3},
+		{`round(-2.4)`, -2},
+		{`round(5)`, 5},
+		{`round("x")`, "argument to `round` must be INTEGER or FLOAT. got STRING"},
+		{`float(true)`, "argument to `float` not supported. got BOOLEAN"},
 	}
 	for _, tt := range ts {
 		eval := testEval(tt.input)
@@ -401,6 +773,18 @@ func TestHashIndexExpression(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			`{2.5: 5}[2.5]`,
+			5,
+		},
+		{
+			`{2.5: 5}[5 / 2.0]`,
+			5,
+		},
+		{
+			`{1: 5}[1.0]`,
+			nil,
+		},
 	}
 	for _, tt := range ts {
 		eval := testEval(tt.input)
@@ -412,3 +796,163 @@ func TestHashIndexExpression(t *testing.T) {
 		}
 	}
 }
+func TestForExpression(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; for (i < 5) { let i = i + 1; }; i;", 5},
+		{"let i = 0; for (false) { let i = i + 1; }; i;", 0},
+		{"let i = 0; for (true) { if (i == 3) { break; } let i = i + 1; }; i;", 3},
+		{
+			`
+			let i = 0;
+			let sum = 0;
+			for (i < 10) {
+				let i = i + 1;
+				if (i > 5) {
+					if (true) { continue; }
+				}
+				let sum = sum + i;
+			}
+			sum;
+			`, 15,
+		},
+		{
+			`
+			let i = 0;
+			let count = 0;
+			for (i < 3) {
+				let i = i + 1;
+				let j = 0;
+				for (true) {
+					if (j == 2) { break; }
+					let j = j + 1;
+					let count = count + 1;
+				}
+			}
+			count;
+			`, 6,
+		},
+		{"let f = fn() { let i = 0; for (true) { if (i == 4) { return i * 10; } let i = i + 1; } }; f();", 40},
+		{"for (false) { 1 }", nil},
+		{"let i = 0; for (i < 3) { let i = i + 1; }", nil},
+	}
+	for _, tt := range ts {
+		eval := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, eval, int64(integer))
+		} else {
+			testNullObject(t, eval)
+		}
+	}
+}
+func TestForRangeExpression(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let sum = 0; for (v range [1, 2, 3]) { sum += v; }; sum;", 6},
+		{"let sum = 0; for (i, v range [10, 20, 30]) { sum += i * v; }; sum;", 80},
+		{"let s = \"\"; for (k, v range {\"b\": 2, \"a\": 1}) { s = s + k + \"${v}\"; }; s;", "a1b2"},
+		{"let s = \"\"; for (k range {\"b\": 2, \"a\": 1}) { s = s + k; }; s;", "ab"},
+		{"let s = \"\"; for (ch range \"你好\") { s = ch + s; }; s;", "好你"},
+		{"let n = 0; for (i, ch range \"你好\") { n = i; }; n;", 1},
+		{"let sum = 0; for (i range 5) { sum += i; }; sum;", 10},
+		{"let sum = 0; for (i range range(1, 10, 2)) { sum += i; }; sum;", 25},
+		{"let sum = 0; for (v range [1, 2, 3, 4]) { if (v == 3) { break; } sum += v; }; sum;", 3},
+		{"let sum = 0; for (v range [1, 2, 3, 4]) { if (v == 2) { continue; } sum += v; }; sum;", 8},
+		{"let f = fn() { for (v range [1, 2, 3]) { if (v == 2) { return v * 10; } } }; f();", 20},
+		{"let fs = []; for (v range [1, 2, 3]) { append!(fs, fn() { v }); }; fs[0]() + fs[2]();", 4},
+		{"let v = 99; for (v range [1, 2]) { }; v;", 99},
+		{"for (v range []) { 1 }", nil},
+	}
+	for _, tt := range ts {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("wrong result. expected=%q, got=%s", expected, evaluated.Inspect())
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestRangeBuiltin(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{"range(3)", "[0, 1, 2]"},
+		{"range(2, 5)", "[2, 3, 4]"},
+		{"range(0, 10, 4)", "[0, 4, 8]"},
+		{"range(3, 0, -1)", "[3, 2, 1]"},
+		{"range(5, 0)", "[]"},
+		{"range(9223372036854775806, 9223372036854775807, 2)", "[9223372036854775806]"},
+		{"range(1, 9223372036854775807, 9223372036854775807)", "[1]"},
+		{"range(9223372036854775805, 9223372036854775807)", "[9223372036854775805, 9223372036854775806]"},
+		{"range(-9223372036854775806, -9223372036854775807 - 1, -3)", "[-9223372036854775806]"},
+		{"range(0, 5, 0)", "ERROR: 1:6: range step cannot be zero"},
+		{"range(\"a\")", "ERROR: 1:6: arguments to `range` must be INTEGER. got STRING"},
+		{"for (v range true) { v }", "ERROR: 1:1: cannot range over BOOLEAN"},
+	}
+	for _, tt := range ts {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result. expected=%q, got=%q", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestForExpressionErrors(t *testing.T) {
+	ts := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"for (1 + true) { 1 }", "type mismatch: INTEGER + BOOLEAN"},
+		{"let i = 0; for (i < 5) { let i = i + 1; if (i == 2) { i + true; } }", "type mismatch: INTEGER + BOOLEAN"},
+		{"break;", "break outside of loop"},
+		{"continue;", "continue outside of loop"},
+		{"let f = fn() { break; }; for (true) { f(); }", "break outside of loop"},
+	}
+	for _, tt := range ts {
+		eval := testEval(tt.input)
+		errobj, ok := eval.(*object.Error)
+		if !ok {
+			t.Errorf("no error obj returned. got=%T(%+v)", eval, eval)
+			continue
+		}
+		if errobj.Message != tt.expectedMessage {
+			t.Errorf("wrong error msg: expected=%q, got=%q", tt.expectedMessage, errobj.Message)
+		}
+	}
+}
+func TestErrorPosition(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1;\nlet b = a + true;", "ERROR: 2:11: type mismatch: INTEGER + BOOLEAN"},
+		{"let f = fn(x) {\n  return -x;\n};\nf(true);", "ERROR: 2:10: unknown operator: -BOOLEAN"},
+		{"\n  foobar;", "ERROR: 2:3: identifier not found: foobar"},
+		{"len(1, 2)", "ERROR: 1:4: wrong number of arguments. got=2, want=1"},
+		{"let i = 0;\nbreak;", "ERROR: 2:1: break outside of loop"},
+	}
+	for _, tt := range ts {
+		eval := testEval(tt.input)
+		errobj, ok := eval.(*object.Error)
+		if !ok {
+			t.Errorf("no error obj returned. got=%T(%+v)", eval, eval)
+			continue
+		}
+		if errobj.Inspect() != tt.expected {
+			t.Errorf("wrong error: expected=%q, got=%q", tt.expected, errobj.Inspect())
+		}
+	}
+}
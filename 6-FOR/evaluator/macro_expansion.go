@@ -1,12 +1,15 @@
 package evaluator
 
 import (
+	"fmt"
 	"malang/ast"
 	"malang/object"
+	"malang/token"
 )
 
 // 获取并替换宏定义
 func DefineMacros(program *ast.Program, env *object.Environment) {
+	importModuleMacros(program, env)
 	definitions := []int{}
 
 	// 查找宏定义
@@ -50,14 +53,78 @@ func addMacro(stmt ast.Statement, env *object.Environment) {
 		Parameters: macroLiteral.Parameters,
 		Env:        env,
 		Body:       macroLiteral.Body,
+		Hygienic:   macroLiteral.Hygienic,
 	}
 
 	env.Set(letStatement.Name.Value, macro)
 }
 
-// 展开宏
-func ExpandMacros(program ast.Node, env *object.Environment) ast.Node {
-	return ast.Modify(program, func(node ast.Node) ast.Node {
+// 宏展开结果中还可以包含宏调用,最多递归展开的层数
+const maxMacroDepth = 100
+
+// 展开宏,宏返回的不是quote或求值出错时返回带位置的错误
+func ExpandMacros(program ast.Node, env *object.Environment) (ast.Node, *object.Error) {
+	return expandMacros(program, env, 0)
+}
+
+// 只展开最外层的一次宏调用,不展开参数和结果中的宏,用于调试
+func ExpandMacroOnce(node ast.Node, env *object.Environment) (ast.Node, *object.Error) {
+	if stmt, ok := node.(*ast.ExpressionStatement); ok {
+		node = stmt.Expression
+	}
+	call, ok := node.(*ast.CallExpression)
+	if !ok {
+		return node, nil
+	}
+	macro, ok := isMacroCall(call, env)
+	if !ok {
+		return node, nil
+	}
+	return expandMacroCall(call, macro)
+}
+
+// macroexpand(quote(...)): 返回完全展开后的quote
+// macroexpand1(quote(...)): 只展开最外层的一次宏调用
+func evalMacroExpand(call *ast.CallExpression, env *object.Environment) object.Object {
+	name := call.Function.TokenLiteral()
+	if len(call.Arguments) != 1 {
+		return newError("wrong number of arguments to `%s`. got=%d, want=1", name, len(call.Arguments))
+	}
+	arg := Eval(call.Arguments[0], env)
+	if isError(arg) {
+		return arg
+	}
+	quoted, ok := arg.(*object.Quote)
+	if !ok {
+		return newError("argument to `%s` must be QUOTE. got %s", name, arg.Type())
+	}
+
+	node := ast.Clone(quoted.Node)
+	var err *object.Error
+	if name == "macroexpand1" {
+		node, err = ExpandMacroOnce(node, env)
+	} else {
+		node, err = ExpandMacros(node, env)
+	}
+	if err != nil {
+		return err
+	}
+	return &object.Quote{Node: node}
+}
+
+func expandMacros(program ast.Node, env *object.Environment, depth int) (ast.Node, *object.Error) {
+	var expandErr *object.Error
+
+	// quote中的代码不展开
+	quoted := hideQuoteArguments(program)
+	defer restoreQuoteArguments(quoted)
+
+	expanded := ast.Modify(program, func(node ast.Node) ast.Node {
+		// 出错后不再展开
+		if expandErr != nil {
+			return node
+		}
+
 		callExpression, ok := node.(*ast.CallExpression)
 		if !ok {
 			return node
@@ -68,17 +135,136 @@ func ExpandMacros(program ast.Node, env *object.Environment) ast.Node {
 			return node
 		}
 
-		args := quoteArgs(callExpression)
-		evalEnv := extendMacroEnv(macro, args)
+		if depth >= maxMacroDepth {
+			expandErr = macroError(callExpression, "macro expansion too deep: %s", callExpression.Function)
+			return node
+		}
 
-		evaluated := Eval(macro.Body, evalEnv)
+		result, err := expandMacroCall(callExpression, macro)
+		if err == nil {
+			// 展开结果中的宏调用
+			result, err = expandMacros(result, env, depth+1)
+		}
+		if err != nil {
+			expandErr = err
+			return node
+		}
+		return result
+	})
 
-		quote, ok := evaluated.(*object.Quote)
-		if !ok {
-			panic("we only support returning AST-nodes from macros ")
+	if expandErr != nil {
+		return program, expandErr
+	}
+	return expanded, nil
+}
+
+// 对宏调用求值一次,返回宏生成的AST
+func expandMacroCall(call *ast.CallExpression, macro *object.Macro) (ast.Node, *object.Error) {
+	name := call.Function.String()
+	if len(call.Arguments) != len(macro.Parameters) {
+		return nil, macroError(call, "wrong number of arguments to macro %s. got=%d, want=%d",
+			name, len(call.Arguments), len(macro.Parameters))
+	}
+
+	args := quoteArgs(call)
+	evalEnv := extendMacroEnv(macro, args)
+
+	evaluated := Eval(macro.Body, evalEnv)
+	if returnValue, ok := evaluated.(*object.ReturnValue); ok {
+		evaluated = returnValue.Value
+	}
+	if err, ok := evaluated.(*object.Error); ok {
+		return nil, err
+	}
+
+	quote, ok := evaluated.(*object.Quote)
+	if !ok {
+		got := "nothing"
+		if evaluated != nil {
+			got = string(evaluated.Type())
 		}
+		return nil, macroError(call, "macro %s must return a QUOTE. got %s", name, got)
+	}
+
+	if macro.Hygienic {
+		return renameMacroBindings(quote.Node, args), nil
+	}
+	return quote.Node, nil
+}
+
+// 宏展开错误,位置为宏调用处
+func macroError(call *ast.CallExpression, format string, a ...interface{}) *object.Error {
+	err := newError(format, a...)
+	err.Pos = call.Pos()
+	return err
+}
+
+// 暂时移除quote(...)的参数,使Modify不会展开其中的宏
+func hideQuoteArguments(node ast.Node) map[*ast.CallExpression][]ast.Expression {
+	hidden := map[*ast.CallExpression][]ast.Expression{}
+	ast.Modify(node, func(node ast.Node) ast.Node {
+		if call, ok := node.(*ast.CallExpression); ok && call.Function.TokenLiteral() == "quote" {
+			hidden[call] = call.Arguments
+			call.Arguments = nil
+		}
+		return node
+	})
+	return hidden
+}
+
+func restoreQuoteArguments(hidden map[*ast.CallExpression][]ast.Expression) {
+	for call, args := range hidden {
+		call.Arguments = args
+	}
+}
+
+// 卫生宏: 将宏自己引入的绑定(let、函数参数、循环变量)重命名为gensym生成的名字,
+// 调用者传入的代码不受影响,因此宏内部的变量不会和调用处的变量冲突
+func renameMacroBindings(expanded ast.Node, args []*object.Quote) ast.Node {
+	fromArgs := map[ast.Node]bool{}
+	for _, arg := range args {
+		ast.Modify(arg.Node, func(node ast.Node) ast.Node {
+			fromArgs[node] = true
+			return node
+		})
+	}
+
+	renames := map[string]string{}
+	bind := func(ident *ast.Identifier) {
+		if ident == nil || fromArgs[ident] {
+			return
+		}
+		if _, ok := renames[ident.Value]; !ok {
+			renames[ident.Value] = gensymName(ident.Value)
+		}
+	}
+	ast.Modify(expanded, func(node ast.Node) ast.Node {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			bind(node.Name)
+		case *ast.FunctionLiteral:
+			for _, param := range node.Parameters {
+				bind(param)
+			}
+		case *ast.ForRangeExpression:
+			bind(node.Key)
+			bind(node.Value)
+		}
+		return node
+	})
+	if len(renames) == 0 {
+		return expanded
+	}
 
-		return quote.Node
+	return ast.Modify(expanded, func(node ast.Node) ast.Node {
+		ident, ok := node.(*ast.Identifier)
+		if !ok || fromArgs[ident] {
+			return node
+		}
+		if name, ok := renames[ident.Value]; ok {
+			return &ast.Identifier{Token: ident.Token, Value: name}
+		}
+		return node
 	})
 }
 
@@ -86,14 +272,21 @@ func isMacroCall(
 	exp *ast.CallExpression,
 	env *object.Environment,
 ) (*object.Macro, bool) {
-	identifier, ok := exp.Function.(*ast.Identifier)
-	if !ok {
-		return nil, false
-	}
-
-	obj, ok := env.Get(identifier.Value)
-	if !ok {
-		return nil, false
+	var obj object.Object
+	switch function := exp.Function.(type) {
+	case *ast.Identifier:
+		obj, _ = env.Get(function.Value)
+	// 模块中的宏 m.unless(...)
+	case *ast.MemberExpression:
+		name, ok := function.Object.(*ast.Identifier)
+		if !ok {
+			return nil, false
+		}
+		if module, ok := env.Get(name.Value); ok {
+			if module, ok := module.(*object.Module); ok {
+				obj, _ = module.Get(function.Member.Value)
+			}
+		}
 	}
 
 	macro, ok := obj.(*object.Macro)
@@ -126,3 +319,18 @@ func extendMacroEnv(
 
 	return extended
 }
+
+// gensym计数器,保证生成的名字唯一
+var gensymCounter int
+
+// 生成唯一的标识符名,#不能出现在源码的标识符中,所以不会和用户的变量冲突
+func gensymName(prefix string) string {
+	gensymCounter++
+	return fmt.Sprintf("%s#%d", prefix, gensymCounter)
+}
+
+// 生成唯一标识符的quote,在宏中配合unquote使用: let unquote(tmp) = 1
+func gensym(prefix string) *object.Quote {
+	name := gensymName(prefix)
+	return &object.Quote{Node: &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}}
+}
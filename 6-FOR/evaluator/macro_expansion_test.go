@@ -110,10 +110,246 @@ func TestExpandMacros(t *testing.T) {
 
 		env := object.NewEnvironment()
 		DefineMacros(program, env)
-		expanded := ExpandMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("ExpandMacros returned error: %s", err.Inspect())
+		}
+
+		if expanded.String() != expected.String() {
+			t.Errorf("not equal. want=%q, got=%q", expected.String(), expanded.String())
+		}
+	}
+}
+
+func TestExpandNestedMacros(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`
+			let double = macro(x) { quote(unquote(x) * 2); };
+			puts(double(1), [double(2)], {"k": double(3)}["k"]);
+			`,
+			`puts((1 * 2), [(2 * 2)], {"k": (3 * 2)}["k"])`,
+		},
+		{
+			`
+			let double = macro(x) { quote(unquote(x) * 2); };
+			double(double(1));
+			`,
+			`((1 * 2) * 2)`,
+		},
+		{
+			`
+			let double = macro(x) { quote(unquote(x) * 2); };
+			for (i < double(5)) { i = double(i); }
+			for (v range double(3)) { double(v) }
+			`,
+			`for (i < (5 * 2)) { i = (i * 2) } for (v range (3 * 2)) { (v * 2) }`,
+		},
+		{
+			`
+			let double = macro(x) { quote(unquote(x) * 2); };
+			double(1); double(2);
+			`,
+			`(1 * 2); (2 * 2);`,
+		},
+	}
+	for _, tt := range ts {
+		expected := testParseProgram(tt.expected)
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("ExpandMacros returned error: %s", err.Inspect())
+		}
 
 		if expanded.String() != expected.String() {
 			t.Errorf("not equal. want=%q, got=%q", expected.String(), expanded.String())
 		}
 	}
 }
+
+func TestExpandMacrosErrors(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"let m = macro(x) { 1 };\nm(2);",
+			"ERROR: 2:2: macro m must return a QUOTE. got INTEGER",
+		},
+		{
+			"let m = macro(x) { };\nm(2);",
+			"ERROR: 2:2: macro m must return a QUOTE. got nothing",
+		},
+		{
+			"let m = macro(x, y) { quote(x) };\nm(2);",
+			"ERROR: 2:2: wrong number of arguments to macro m. got=1, want=2",
+		},
+		{
+			"let m = macro(x) { 1 + true };\nm(2);",
+			"ERROR: 1:22: type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			"let m = macro() { return quote(1); 2 };\nm();",
+			"",
+		},
+	}
+	for _, tt := range ts {
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		_, err := ExpandMacros(program, env)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected error: %s", err.Inspect())
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("expected error %q, got nil", tt.expected)
+			continue
+		}
+		if err.Inspect() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Inspect())
+		}
+	}
+}
+
+// 定义并展开宏后求值,宏和值共用一个环境
+func testEvalWithMacros(input string) object.Object {
+	program := testParseProgram(input)
+	env := object.NewEnvironment()
+
+	DefineMacros(program, env)
+	expanded, err := ExpandMacros(program, env)
+	if err != nil {
+		return err
+	}
+	return Eval(expanded, env)
+}
+
+func TestGensym(t *testing.T) {
+	a, ok := testEval(`gensym("tmp")`).(*object.Quote)
+	if !ok {
+		t.Fatalf("gensym did not return QUOTE")
+	}
+	b := testEval(`gensym("tmp")`).(*object.Quote)
+	if a.Node.String() == b.Node.String() {
+		t.Errorf("gensym returned the same name twice: %s", a.Node)
+	}
+	if _, ok := a.Node.(*ast.Identifier); !ok {
+		t.Errorf("gensym node is not *ast.Identifier. got=%T", a.Node)
+	}
+
+	evaluated := testEval(`gensym(1)`)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Message != "argument to `gensym` must be STRING. got INTEGER" {
+		t.Errorf("wrong error. got=%s", evaluated.Inspect())
+	}
+}
+
+func TestHygienicMacros(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{
+			// 宏内部的tmp和调用处的tmp冲突,交换失败
+			`
+			let swap = macro(a, b) { quote(if (true) { let tmp = unquote(a); unquote(a) = unquote(b); unquote(b) = tmp; }) };
+			let tmp = 1; let y = 2;
+			swap(tmp, y);
+			[tmp, y];
+			`,
+			"[2, 2]",
+		},
+		{
+			`
+			let swap = hygienic macro(a, b) { quote(if (true) { let tmp = unquote(a); unquote(a) = unquote(b); unquote(b) = tmp; }) };
+			let tmp = 1; let y = 2;
+			swap(tmp, y);
+			[tmp, y];
+			`,
+			"[2, 1]",
+		},
+		{
+			// 参数中的同名变量不会被重命名
+			`
+			let twice = hygienic macro(x) { quote(fn(v) { unquote(x) + v }) };
+			let v = 10;
+			twice(v)(1);
+			`,
+			"11",
+		},
+		{
+			`
+			let each = hygienic macro(arr, body) { quote(for (el range unquote(arr)) { unquote(body) }) };
+			let el = 0; let sum = 0;
+			each([1, 2, 3], sum += el);
+			sum;
+			`,
+			"0",
+		},
+		{
+			`
+			let adder = macro(v) { let n = gensym("n"); quote(fn(unquote(n)) { unquote(n) + unquote(v) }) };
+			let n = 100;
+			adder(n)(1);
+			`,
+			"101",
+		},
+		{
+			`
+			let define = macro(name) { let tmp = gensym(); quote(if (true) { let unquote(tmp) = 5; unquote(name) = unquote(tmp) * 2; }) };
+			let x = 0;
+			define(x);
+			x;
+			`,
+			"10",
+		},
+	}
+	for _, tt := range ts {
+		evaluated := testEvalWithMacros(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result. want=%q, got=%q", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestMacroExpand(t *testing.T) {
+	definitions := `
+	let unless = macro(c, a, b) { quote(if (!(unquote(c))) { unquote(a) } else { unquote(b) }) };
+	let double = macro(x) { quote(unquote(x) * 2) };
+	let quadruple = macro(x) { quote(double(double(unquote(x)))) };
+	let forever = macro(x) { quote(forever(unquote(x))) };
+	`
+
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{`macroexpand(quote(unless(x, y, z)))`, "QUOTE(if(!x) yelse z)"},
+		{`macroexpand(quote(unless(double(1), y, z)))`, "QUOTE(if(!(1 * 2)) yelse z)"},
+		{`macroexpand1(quote(unless(double(1), y, z)))`, "QUOTE(if(!double(1)) yelse z)"},
+		{`macroexpand(quote(quadruple(3)))`, "QUOTE(((3 * 2) * 2))"},
+		{`macroexpand1(quote(quadruple(3)))`, "QUOTE(double(double(3)))"},
+		{`macroexpand(quote(x + 1))`, "QUOTE((x + 1))"},
+		{`quote(double(1))`, "QUOTE(double(1))"},
+		{`quadruple(3)`, "12"},
+		{`macroexpand(1)`, "ERROR: 6:13: argument to `macroexpand` must be QUOTE. got INTEGER"},
+		// 错误位置是宏体中递归生成的调用
+		{`forever(1)`, "ERROR: 5:40: macro expansion too deep: forever"},
+	}
+	for _, tt := range ts {
+		evaluated := testEvalWithMacros(definitions + tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
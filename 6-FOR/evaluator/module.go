@@ -0,0 +1,292 @@
+package evaluator
+
+import (
+	"malang/ast"
+	"malang/object"
+	"malang/parser"
+	"malang/util"
+	"path/filepath"
+	"strings"
+)
+
+// 已加载的模块,按文件绝对路径缓存,每个文件只运行一次
+var modules = map[string]*object.Module{}
+
+// 标准库模块的名字,use std导入标准库
+const StdModuleName = "std"
+
+// 模块使用的标准库,只运行一次
+var stdEnv *object.Environment
+
+// 模块的根环境: 只有内置函数和标准库,不能访问导入者的全局变量
+// 每个模块得到标准库绑定的副本,模块中的赋值不影响其它模块
+func NewModuleEnvironment() (*object.Environment, *object.Error) {
+	if stdEnv == nil {
+		env := object.NewEnvironment()
+		if err := EvalStd(env); err != nil {
+			return nil, err
+		}
+		stdEnv = env
+	}
+	return stdEnv.Copy(), nil
+}
+
+// 正在加载的模块链(导入顺序),用于检测循环导入
+var loading []loadingModule
+
+type loadingModule struct {
+	path string // 报错时显示的路径
+	key  string // 绝对路径
+}
+
+// 解析use表达式,在当前环境中绑定模块或选择导入的名字,返回模块
+func evalUseExpression(node *ast.UseExpression, env *object.Environment) object.Object {
+	module, err := loadModule(node.FileName, node.Pos().File)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case node.Names != nil:
+		for _, name := range node.Names {
+			value := GetMember(module, name.Value)
+			if err, ok := value.(*object.Error); ok {
+				err.Pos = name.Pos()
+				return err
+			}
+			env.Set(name.Value, value)
+		}
+	case node.Alias != nil:
+		env.Set(node.Alias.Value, module)
+	default:
+		env.Set(module.Name, module)
+	}
+	return module
+}
+
+// 成员访问,目前只支持模块
+func evalMemberExpression(node *ast.MemberExpression, env *object.Environment) object.Object {
+	obj := Eval(node.Object, env)
+	if isError(obj) {
+		return obj
+	}
+	return GetMember(obj, node.Member.Value)
+}
+
+// 取模块导出的成员,虚拟机也使用这个函数
+func GetMember(obj object.Object, name string) object.Object {
+	module, ok := obj.(*object.Module)
+	if !ok {
+		return newError("member access not supported: %s", obj.Type())
+	}
+	value, ok := module.Get(name)
+	if !ok {
+		return newError("module %s does not export %s", module.Name, name)
+	}
+	return value
+}
+
+// 宏展开前收集顶层use导入的宏,使模块中的宏可以在当前程序中展开
+// 这里只定义模块中的宏,不运行模块,模块在求值use时按源码顺序运行
+// 找不到或解析失败的模块忽略,求值use时会报告错误
+func importModuleMacros(program *ast.Program, env *object.Environment) {
+	for _, statement := range program.Statements {
+		stmt, ok := statement.(*ast.ExpressionStatement)
+		if !ok {
+			continue
+		}
+		use, ok := stmt.Expression.(*ast.UseExpression)
+		if !ok {
+			continue
+		}
+		module := moduleMacros(use.FileName, use.Pos().File)
+		if module == nil {
+			continue
+		}
+
+		switch {
+		case use.Names != nil:
+			for _, name := range use.Names {
+				if macro, ok := module.Get(name.Value); ok {
+					env.Set(name.Value, macro)
+				}
+			}
+		case use.Alias != nil:
+			env.Set(use.Alias.Value, module)
+		default:
+			env.Set(module.Name, module)
+		}
+	}
+}
+
+// 正在收集宏的模块,避免循环导入时无限递归
+var collectingMacros = map[string]bool{}
+
+// 解析模块并只定义其中的宏,返回只包含宏的模块,模块没有可导入的宏时返回nil
+func moduleMacros(name, importer string) *object.Module {
+	var program *ast.Program
+	var diagnostics []*parser.Diagnostic
+	var err error
+	root, rootErr := NewModuleEnvironment()
+	if rootErr != nil {
+		return nil
+	}
+	module := &object.Module{Env: object.NewEnclosedEnvironment(root)}
+
+	if name == StdModuleName+util.MAL_EXT {
+		module.Name, module.Path = StdModuleName, StdModuleName
+		program, diagnostics, err = util.LoadStd()
+	} else {
+		path, resolveErr := util.ResolveModule(name, importer)
+		if resolveErr != nil {
+			return nil
+		}
+		key, absErr := filepath.Abs(path)
+		if absErr != nil || collectingMacros[key] {
+			return nil
+		}
+		collectingMacros[key] = true
+		defer delete(collectingMacros, key)
+
+		module.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		module.Path = path
+		program, diagnostics, err = util.LoadMalFile(path)
+	}
+	if err != nil || len(diagnostics) != 0 {
+		return nil
+	}
+	if module.Path != StdModuleName {
+		module.Exports = ModuleExports(program)
+	}
+
+	macros := []string{}
+	for _, statement := range program.Statements {
+		if isMacroDefinition(statement) {
+			macros = append(macros, statement.(*ast.LetStatement).Name.Value)
+		}
+	}
+	DefineMacros(program, module.Env)
+
+	for _, macro := range macros {
+		if _, ok := module.Get(macro); ok {
+			return module
+		}
+	}
+	return nil
+}
+
+// 查找、解析并运行模块
+// 模块有自己的根环境,只能使用内置函数和标准库
+func loadModule(name, importer string) (*object.Module, *object.Error) {
+	if name == StdModuleName+util.MAL_EXT {
+		return loadStdModule()
+	}
+	path, err := util.ResolveModule(name, importer)
+	if err != nil {
+		return nil, newError("%s", err)
+	}
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return nil, newError("%s", err)
+	}
+
+	if module, ok := modules[key]; ok {
+		return module, nil
+	}
+	for i, m := range loading {
+		if m.key == key {
+			chain := []string{}
+			for _, m := range loading[i:] {
+				chain = append(chain, m.path)
+			}
+			chain = append(chain, path)
+			return nil, newError("import cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	program, diagnostics, err := util.LoadMalFile(path)
+	if err != nil {
+		return nil, newError("%s", err)
+	}
+	if len(diagnostics) != 0 {
+		return nil, newError("cannot parse module %s: %s", path, diagnostics[0])
+	}
+
+	loading = append(loading, loadingModule{path: path, key: key})
+	defer func() { loading = loading[:len(loading)-1] }()
+
+	root, rootErr := NewModuleEnvironment()
+	if rootErr != nil {
+		return nil, rootErr
+	}
+	module := &object.Module{
+		Name:    strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Path:    path,
+		Env:     object.NewEnclosedEnvironment(root),
+		Exports: ModuleExports(program),
+	}
+	moduleEnv := module.Env
+	DefineMacros(program, moduleEnv)
+	expanded, expandErr := ExpandMacros(program, moduleEnv)
+	if expandErr != nil {
+		return nil, expandErr
+	}
+	if result := Eval(expanded, moduleEnv); isError(result) {
+		return nil, result.(*object.Error)
+	}
+
+	modules[key] = module
+	return module, nil
+}
+
+// 标准库作为模块导入,和预加载的标准库是不同的实例
+func loadStdModule() (*object.Module, *object.Error) {
+	if module, ok := modules[StdModuleName]; ok {
+		return module, nil
+	}
+	module := &object.Module{
+		Name: StdModuleName,
+		Path: StdModuleName,
+		Env:  object.NewEnvironment(),
+	}
+	if err := EvalStd(module.Env); err != nil {
+		return nil, err
+	}
+	modules[StdModuleName] = module
+	return module, nil
+}
+
+// 在env中运行标准库,repl和运行文件时作为prelude预加载
+func EvalStd(env *object.Environment) *object.Error {
+	program, diagnostics, err := util.LoadStd()
+	if err != nil {
+		return newError("cannot load std: %s", err)
+	}
+	if len(diagnostics) != 0 {
+		return newError("cannot parse std: %s", diagnostics[0])
+	}
+
+	DefineMacros(program, env)
+	expanded, expandErr := ExpandMacros(program, env)
+	if expandErr != nil {
+		return expandErr
+	}
+	if result := Eval(expanded, env); isError(result) {
+		return result.(*object.Error)
+	}
+	return nil
+}
+
+// 模块中export let的名字,没有使用export时返回nil
+func ModuleExports(program *ast.Program) map[string]bool {
+	var exports map[string]bool
+	for _, statement := range program.Statements {
+		if let, ok := statement.(*ast.LetStatement); ok && let.Exported {
+			if exports == nil {
+				exports = map[string]bool{}
+			}
+			exports[let.Name.Value] = true
+		}
+	}
+	return exports
+}
@@ -0,0 +1,266 @@
+package evaluator
+
+import (
+	"malang/lexer"
+	"malang/object"
+	"malang/parser"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 在dir中写入模块文件
+func writeModules(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// 以dir/main.mal的身份运行input
+func testEvalFile(dir, input string) object.Object {
+	l := lexer.NewWithFile(filepath.Join(dir, "main.mal"), input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	env := object.NewEnvironment()
+	DefineMacros(program, env)
+	expanded, err := ExpandMacros(program, env)
+	if err != nil {
+		return err
+	}
+	return Eval(expanded, env)
+}
+
+// 检查模块测试的结果: int、string或errorMessage
+func testModuleResult(t *testing.T, input string, expected interface{}, evaluated object.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		testIntegerObject(t, evaluated, int64(expected))
+	case string:
+		str, ok := evaluated.(*object.String)
+		if !ok || str.Value != expected {
+			t.Errorf("wrong result for %s. want=%q, got=%s", input, expected, evaluated.Inspect())
+		}
+	case errorMessage:
+		err, ok := evaluated.(*object.Error)
+		if !ok || err.Message != string(expected) {
+			t.Errorf("wrong error for %s. want=%q, got=%s", input, expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestUseModule(t *testing.T) {
+	dir := t.TempDir()
+	writeModules(t, dir, map[string]string{
+		"math.mal":         `let double = fn(x) { x * 2 };`,
+		"lib/strings.mal":  `use helper; let greet = fn(n) { helper.prefix + n };`,
+		"lib/helper.mal":   `let prefix = "hi ";`,
+		"state.mal":        `let items = [];`,
+		"broken.mal":       `let x = ;`,
+		"failing.mal":      `let x = 1 + true;`,
+		"cycle/a.mal":      `use b; let a = 1;`,
+		"cycle/b.mal":      `use c; let b = 1;`,
+		"cycle/c.mal":      `use a; let c = 1;`,
+		"self.mal":         `use self;`,
+		"uses_missing.mal": `use nope;`,
+	})
+
+	ts := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`use math; math.double(4)`, 8},
+		{`use "math.mal"; math.double(5)`, 10},
+		// 嵌套目录中的模块相对于自己所在的目录查找
+		{`use "lib/strings"; strings.greet("mal")`, "hi mal"},
+		// 模块只运行一次,再次导入得到同一个数组
+		{`use state; append!(state.items, 1); use state; len(state.items)`, 1},
+		{`use nope`, errorMessage("module not found: nope.mal (searched " + dir + ")")},
+		{`use uses_missing`, errorMessage("module not found: nope.mal (searched " + dir + ")")},
+		{`use broken`, errorMessage("cannot parse module " + filepath.Join(dir, "broken.mal") + ": " +
+			filepath.Join(dir, "broken.mal") + ":1:9: no prefix parse function for ; found")},
+		{`use failing`, errorMessage("type mismatch: INTEGER + BOOLEAN")},
+		{`use "cycle/a"`, errorMessage("import cycle: " + filepath.Join(dir, "cycle/a.mal") + " -> " +
+			filepath.Join(dir, "cycle/b.mal") + " -> " + filepath.Join(dir, "cycle/c.mal") + " -> " +
+			filepath.Join(dir, "cycle/a.mal"))},
+		{`use self`, errorMessage("import cycle: " + filepath.Join(dir, "self.mal") + " -> " + filepath.Join(dir, "self.mal"))},
+	}
+	for _, tt := range ts {
+		testModuleResult(t, tt.input, tt.expected, testEvalFile(dir, tt.input))
+	}
+}
+
+func TestUseModuleSearchPath(t *testing.T) {
+	dir, lib := t.TempDir(), t.TempDir()
+	writeModules(t, lib, map[string]string{"shared.mal": `let answer = 42;`})
+	t.Setenv("MALANG_PATH", string(filepath.ListSeparator)+lib)
+
+	testIntegerObject(t, testEvalFile(dir, `use shared; shared.answer`), 42)
+
+	// 导入者所在目录优先于MALANG_PATH
+	writeModules(t, dir, map[string]string{"shared.mal": `let answer = 1;`})
+	testIntegerObject(t, testEvalFile(dir, `use shared; shared.answer`), 1)
+}
+
+func TestModuleNamespaces(t *testing.T) {
+	dir := t.TempDir()
+	writeModules(t, dir, map[string]string{
+		"strings.mal": `
+			let _sep = ",";
+			let join = fn(a, b) { a + _sep + b };
+			let split = fn(s) { [s] };
+			let len = fn(s) { "shadowed" };
+		`,
+		"exports.mal": `
+			let helper = fn(x) { x + 1 };
+			export let inc = fn(x) { helper(x) };
+		`,
+		"macros.mal": `
+			export let unless = macro(c, a, b) { quote(if (!(unquote(c))) { unquote(a) } else { unquote(b) }) };
+		`,
+		"globals.mal": `
+			export let read = fn() { secret };
+			export let write = fn() { secret = 99 };
+		`,
+		"stdcopy.mal": `
+			export let before = sum([1, 2]);
+			sum = fn(arr) { 0 };
+			export let after = sum([1, 2]);
+		`,
+	})
+
+	ts := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`use strings; strings.join("a", "b")`, "a,b"},
+		{`use strings as s; s.join("a", "b")`, "a,b"},
+		{`use strings { join, split }; join("a", "b")`, "a,b"},
+		// 模块的定义不会覆盖调用者的绑定
+		{`use strings; len("abc")`, 3},
+		{`let join = 1; use strings as s; join`, 1},
+		{`use strings; strings._sep`, errorMessage("module strings does not export _sep")},
+		{`use strings { _sep }`, errorMessage("module strings does not export _sep")},
+		{`use strings; strings.nope`, errorMessage("module strings does not export nope")},
+		{`use strings; _sep`, errorMessage("identifier not found: _sep")},
+		// 使用export后只有export的名字可以访问
+		{`use exports; exports.inc(1)`, 2},
+		{`use exports; exports.helper(1)`, errorMessage("module exports does not export helper")},
+		{`use exports { inc }; inc(2)`, 3},
+		{`1.x`, errorMessage("member access not supported: INTEGER")},
+		// 模块中的宏
+		{`use macros; macros.unless(false, 1, 2)`, 1},
+		{`use macros as m; m.unless(true, 1, 2)`, 2},
+		{`use macros { unless }; unless(false, 1, 2)`, 1},
+		// 模块有自己的根环境,不能读取或修改导入者的全局变量
+		{`let secret = 1; use globals; globals.read()`, errorMessage("identifier not found: secret")},
+		{`let secret = 1; use globals; globals.write()`, errorMessage("assignment to undeclared variable: secret")},
+		// 模块可以使用标准库,修改的是自己的副本
+		{`use stdcopy; stdcopy.before + stdcopy.after`, 3},
+		{`use stdcopy; let sum = fn(arr) { 100 }; use std; std.sum([1, 2]) + sum([])`, 103},
+	}
+	for _, tt := range ts {
+		testModuleResult(t, tt.input, tt.expected, testEvalFile(dir, tt.input))
+	}
+}
+
+// 模块在求值use时才运行,不会早于前面的语句
+func TestUseModuleRunsInOrder(t *testing.T) {
+	dir := t.TempDir()
+	// 模块不能访问导入者的全局变量,通过共同导入的log模块记录运行顺序
+	writeModules(t, dir, map[string]string{
+		"log.mal":    `let entries = [];`,
+		"logger.mal": `use log; append!(log.entries, "module");`,
+		"macros.mal": `
+			use log;
+			append!(log.entries, "macros");
+			export let twice = macro(x) { quote(unquote(x) * 2) };
+		`,
+	})
+
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{`use log; append!(log.entries, "first"); use logger; log.entries`, `[first, module]`},
+		{`use log; append!(log.entries, "first"); if (true) { use logger }; log.entries`, `[first, module]`},
+		// 宏在运行模块之前就可以展开
+		{`use log; append!(log.entries, "first"); use macros { twice }; append!(log.entries, twice(2)); log.entries`, `[first, macros, 4]`},
+	}
+	for _, tt := range ts {
+		l := lexer.NewWithFile(filepath.Join(dir, "main.mal"), tt.input)
+		program := parser.New(l).ParseProgram()
+		env := object.NewEnvironment()
+		modules = map[string]*object.Module{}
+
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("expand error for %s: %s", tt.input, err.Inspect())
+		}
+		evaluated := Eval(expanded, env)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestStdModule(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`use std; std.sum([1, 2, 3])`, 6},
+		{`use std as s; s.reduce(s.map([1, 2], fn(x) { x * 2 }), 0, fn(a, b) { a + b })`, 6},
+		{`use std { sum }; sum([4, 5])`, 9},
+		{`use std { nope }`, errorMessage("module std does not export nope")},
+	}
+	for _, tt := range ts {
+		testModuleResult(t, tt.input, tt.expected, testEvalFile(t.TempDir(), tt.input))
+	}
+}
+
+func TestEvalStd(t *testing.T) {
+	env := object.NewEnvironment()
+	if err := EvalStd(env); err != nil {
+		t.Fatalf("embedded std failed: %s", err.Inspect())
+	}
+	if _, ok := env.Get("map"); !ok {
+		t.Errorf("map is not defined by std")
+	}
+
+	// 覆盖目录(包括子目录)中的文件按路径顺序加载
+	dir := t.TempDir()
+	writeModules(t, dir, map[string]string{
+		"b.mal":       `let two = one + 1;`,
+		"a.mal":       `let one = 1;`,
+		"lib/c.mal":   `let three = two + 1;`,
+		"lib/d/e.mal": `let four = three + 1;`,
+		"notes.txt":   `not mal`,
+	})
+	t.Setenv("MALANG_STD", dir)
+	env = object.NewEnvironment()
+	if err := EvalStd(env); err != nil {
+		t.Fatalf("std override failed: %s", err.Inspect())
+	}
+	four, _ := env.Get("four")
+	testIntegerObject(t, four, 4)
+	if _, ok := env.Get("map"); ok {
+		t.Errorf("embedded std was loaded despite override")
+	}
+
+	writeModules(t, dir, map[string]string{"c.mal": `let x = ;`})
+	err := EvalStd(object.NewEnvironment())
+	expected := "cannot parse std: " + filepath.Join(dir, "c.mal") + ":1:9: no prefix parse function for ; found"
+	if err == nil || err.Message != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+}
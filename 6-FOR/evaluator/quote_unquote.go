@@ -8,12 +8,32 @@ import (
 )
 
 func quote(node ast.Node, env *object.Environment) object.Object {
-	node = evalUnquoteCalls(node, env)
+	// 拷贝后再替换unquote,避免修改宏体或函数体本身的AST
+	node, err := evalUnquoteCalls(ast.Clone(node), env)
+	if err != nil {
+		return err
+	}
 	return &object.Quote{Node: node}
 }
 
-func evalUnquoteCalls(quoted ast.Node, env *object.Environment) ast.Node {
-	return ast.Modify(quoted, func(node ast.Node) ast.Node {
+func evalUnquoteCalls(quoted ast.Node, env *object.Environment) (ast.Node, *object.Error) {
+	var unquoteErr *object.Error
+
+	node := ast.Modify(quoted, func(node ast.Node) ast.Node {
+		if unquoteErr != nil {
+			return node
+		}
+
+		// let unquote(name) = ... 中被绑定的名字
+		if ident, ok := node.(*ast.Identifier); ok && ident.Unquote != nil {
+			renamed, err := unquoteIdentifier(ident, env)
+			if err != nil {
+				unquoteErr = err
+				return node
+			}
+			return renamed
+		}
+
 		if !isUnquoteCall(node) {
 			return node
 		}
@@ -24,37 +44,134 @@ func evalUnquoteCalls(quoted ast.Node, env *object.Environment) ast.Node {
 		}
 
 		if len(call.Arguments) != 1 {
+			unquoteErr = newError("wrong number of arguments to `unquote`. got=%d, want=1", len(call.Arguments))
+			unquoteErr.Pos = call.Pos()
 			return node
 		}
 
-		unquoted := Eval(call.Arguments[0], env)
-		return convertObjectToASTNode(unquoted)
+		unquoted, err := convertObjectToASTNode(Eval(call.Arguments[0], env), call.Pos())
+		if err != nil {
+			unquoteErr = err
+			return node
+		}
+		return unquoted
 	})
+	return node, unquoteErr
+}
+
+// 对unquote(x)形式的绑定名求值,结果必须是标识符的quote(如gensym())或字符串
+func unquoteIdentifier(ident *ast.Identifier, env *object.Environment) (*ast.Identifier, *object.Error) {
+	val := Eval(ident.Unquote, env)
+	switch val := val.(type) {
+	case *object.Error:
+		return nil, val
+	case *object.String:
+		return &ast.Identifier{Token: ident.Token, Value: val.Value}, nil
+	case *object.Quote:
+		if quoted, ok := val.Node.(*ast.Identifier); ok {
+			return &ast.Identifier{Token: ident.Token, Value: quoted.Value}, nil
+		}
+	}
+	err := newError("unquoted name must be an identifier. got %s", describeObject(val))
+	err.Pos = ident.Pos()
+	return nil, err
 }
 
-// obj转astNode
-func convertObjectToASTNode(obj object.Object) ast.Node {
+// 错误信息中描述对象,quote显示其内容
+func describeObject(obj object.Object) string {
+	if obj == nil {
+		return "nothing"
+	}
+	if quote, ok := obj.(*object.Quote); ok {
+		return quote.Inspect()
+	}
+	return string(obj.Type())
+}
+
+// obj转astNode,生成的节点使用unquote调用处的位置
+// 函数会内联为函数字面量(闭包捕获的变量不会一起带走),内置函数和宏无法转换
+func convertObjectToASTNode(obj object.Object, pos token.Position) (ast.Node, *object.Error) {
 	switch obj := obj.(type) {
+	case *object.Error:
+		return nil, obj
 	case *object.Integer:
 		t := token.Token{
 			Type:    token.INT,
 			Literal: fmt.Sprintf("%d", obj.Value),
+			Pos:     pos,
+		}
+		return &ast.IntegerLiteral{Token: t, Value: obj.Value}, nil
+	case *object.Float:
+		t := token.Token{
+			Type:    token.FLOAT,
+			Literal: object.FormatFloat(obj.Value),
+			Pos:     pos,
 		}
-		return &ast.IntegerLiteral{Token: t, Value: obj.Value}
+		return &ast.FloatLiteral{Token: t, Value: obj.Value}, nil
 	case *object.Boolean:
 		var t token.Token
 		if obj.Value {
-			t = token.Token{Type: token.TRUE, Literal: "true"}
+			t = token.Token{Type: token.TRUE, Literal: "true", Pos: pos}
 		} else {
-			t = token.Token{Type: token.FALSE, Literal: "false"}
+			t = token.Token{Type: token.FALSE, Literal: "false", Pos: pos}
 		}
-		return &ast.Boolean{Token: t, Value: obj.Value}
+		return &ast.Boolean{Token: t, Value: obj.Value}, nil
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value, Pos: pos}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}, nil
+	case *object.Null:
+		return &ast.NullLiteral{Token: token.Token{Type: token.NULL, Literal: "null", Pos: pos}}, nil
+	case *object.Array:
+		elements := make([]ast.Expression, len(obj.Elements))
+		for i, el := range obj.Elements {
+			node, err := convertObjectToExpression(el, pos)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = node
+		}
+		t := token.Token{Type: token.LBRACKET, Literal: "[", Pos: pos}
+		return &ast.ArrayLiteral{Token: t, Elements: elements}, nil
+	case *object.Hash:
+		pairs := make(map[ast.Expression]ast.Expression, len(obj.Pairs))
+		for _, pair := range obj.SortedPairs() {
+			key, err := convertObjectToExpression(pair.Key, pos)
+			if err != nil {
+				return nil, err
+			}
+			value, err := convertObjectToExpression(pair.Value, pos)
+			if err != nil {
+				return nil, err
+			}
+			pairs[key] = value
+		}
+		t := token.Token{Type: token.LBRACE, Literal: "{", Pos: pos}
+		return &ast.HashLiteral{Token: t, Pairs: pairs}, nil
+	case *object.Function:
+		t := token.Token{Type: token.FUNCTION, Literal: "fn", Pos: pos}
+		fn := &ast.FunctionLiteral{Token: t, Parameters: obj.Parameters, Body: obj.Body}
+		return ast.Clone(fn), nil
 	case *object.Quote:
-		return obj.Node
+		return obj.Node, nil
+	}
+	err := newError("cannot unquote %s", describeObject(obj))
+	err.Pos = pos
+	return nil, err
+}
 
-	default:
-		return nil
+// 转换为表达式,quote中的语句(如let)不能作为数组元素等使用
+func convertObjectToExpression(obj object.Object, pos token.Position) (ast.Expression, *object.Error) {
+	node, err := convertObjectToASTNode(obj, pos)
+	if err != nil {
+		return nil, err
+	}
+	exp, ok := node.(ast.Expression)
+	if !ok {
+		err := newError("cannot unquote %s as an expression", describeObject(obj))
+		err.Pos = pos
+		return nil, err
 	}
+	return exp, nil
 }
 
 func isUnquoteCall(node ast.Node) bool {
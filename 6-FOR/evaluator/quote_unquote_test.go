@@ -75,6 +75,34 @@ func TestQuote(t *testing.T) {
 			`,
 			`(8 + (4 + 4))`,
 		},
+		{
+			`quote(unquote(1.5 * 2))`,
+			`3.0`,
+		},
+		{
+			`quote(unquote(1 / 4.0) + 1)`,
+			`(0.25 + 1)`,
+		},
+		{
+			`quote(unquote("ab" + "c"))`,
+			`abc`,
+		},
+		{
+			`quote(unquote([1, "a", [true, null]]))`,
+			`[1, a, [true, null]]`,
+		},
+		{
+			`quote(unquote({"a": [1, 2]}))`,
+			`{a:[1, 2]}`,
+		},
+		{
+			`quote(unquote(null))`,
+			`null`,
+		},
+		{
+			`quote(unquote(fn(x) { x + 1 })(2))`,
+			`fn(x) (x + 1)(2)`,
+		},
 	}
 
 	for _, tt := range ts {
@@ -91,3 +119,27 @@ func TestQuote(t *testing.T) {
 		}
 	}
 }
+
+func TestUnquoteValues(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{`let m = macro() { quote(unquote("a" + "b")) }; m()`, `ab`},
+		{`let m = macro() { quote(unquote([1, [2, 3]])) }; m()`, `[1, [2, 3]]`},
+		{`let m = macro() { quote(unquote({"b": 2, "a": [1]})) }; m()`, `{a: [1], b: 2}`},
+		{`let m = macro() { quote(unquote(null)) }; m()`, `null`},
+		{`let m = macro() { quote(unquote(fn(x) { x + 1 })(2)) }; m()`, `3`},
+		{`let m = macro() { quote(unquote(len)) }; m()`, `ERROR: 1:32: cannot unquote BUILTIN`},
+		{`let m = macro() { quote(unquote([1, len])) }; m()`, `ERROR: 1:32: cannot unquote BUILTIN`},
+		{`let m = macro() { quote(unquote(missing)) }; m()`, `ERROR: 1:33: identifier not found: missing`},
+		{`let m = macro() { quote(unquote(1, 2)) }; m()`, "ERROR: 1:32: wrong number of arguments to `unquote`. got=2, want=1"},
+		{`quote()`, "ERROR: 1:6: wrong number of arguments to `quote`. got=0, want=1"},
+	}
+	for _, tt := range ts {
+		evaluated := testEvalWithMacros(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
@@ -3,53 +3,95 @@ package lexer
 
 import (
 	"malang/token"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
 	input        string
 	position     int  // 输入的字符串中的当前位置(指向当前字符)
 	readPosition int  // 输入的字符串中的当前读取位置(指向当前字符串之后的一个字符(ch))
-	ch           byte // 当前正在查看的字符
+	ch           rune // 当前正在查看的字符(按utf-8解码)
+
+	file   string // 源文件名,用于报错
+	line   int    // 当前字符所在行
+	column int    // 当前字符所在列
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFile("", input)
+}
+
+// 创建词法分析器,并记录源文件名
+func NewWithFile(file, input string) *Lexer {
+	return NewAt(token.Position{File: file, Line: 1, Column: 1}, input)
+}
+
+// 创建从指定位置开始的词法分析器(如字符串插值中的表达式)
+func NewAt(pos token.Position, input string) *Lexer {
+	l := &Lexer{input: input, file: pos.File, line: pos.Line, column: pos.Column - 1}
 	// 初始化 l.ch,l.position,l.readPosition
 	l.readChar()
 	return l
 }
 
+// 当前字符的位置
+func (l *Lexer) pos() token.Position {
+	return token.Position{File: l.file, Line: l.line, Column: l.column}
+}
+
 // 读取下一个字符
 func (l *Lexer) readChar() {
+	// 跨过换行符时行号加一
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+	width := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0 // NUL的ASSII码(0)
 	} else {
-		// 读取
-		l.ch = l.input[l.readPosition]
+		// 读取一个完整的utf-8字符,中文等多字节字符占多个字节
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
-	// 前移
+	// 前移(position和readPosition都是字节偏移)
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += width
 }
 
 // 创建词法单元的方法
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func (l *Lexer) newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{
 		Type:    tokenType,
 		Literal: string(ch),
+		Pos:     l.pos(),
 	}
 }
 
-// 判断读取到的字符是不是字母
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// 创建由当前字符和下一个字符组成的词法单元(如 <= >>)
+func (l *Lexer) newTwoCharToken(tokenType token.TokenType) token.Token {
+	pos := l.pos()
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: string(ch) + string(l.ch), Pos: pos}
+}
+
+// 判断读取到的字符是不是字母(包括中文等unicode字母)
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
 // 读取字母(标识符/关键字)
 func (l *Lexer) readIdentifier() string {
 	position := l.position
-	for isLetter(l.ch) {
-		// 如果接下来还有字母,就一直移动指针到不是字母
+	for isLetter(l.ch) || isDigit(l.ch) {
+		// 第一个字符是字母,之后可以是字母或数字,如 macroexpand1
+		l.readChar()
+	}
+	// 原地修改数据的函数名以!结尾,如 append!(arr, 1)
+	if l.ch == '!' && l.peekChar() == '(' {
 		l.readChar()
 	}
 	return l.input[position:l.position]
@@ -63,50 +105,93 @@ func (l *Lexer) skipWhitespace() {
 }
 
 // 判断是否是数字
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-// 读取数字
-func (l *Lexer) readNumber() string {
+// 读取数字(整数或浮点数)
+func (l *Lexer) readNumber() (token.TokenType, string) {
 	// 记录起始位置
 	position := l.position
+	tokenType := token.TokenType(token.INT)
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
+	// 小数部分 3.14 .5
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+	}
+	// 指数部分 1e-9 2.5E+3
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if next == '+' || next == '-' {
+			next = l.peekCharAt(2)
+		}
+		if isDigit(next) {
+			tokenType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			for isDigit(l.ch) {
+				l.readChar()
+			}
+		}
+	}
+	return tokenType, l.input[position:l.position]
 }
 
 // 向前查看一个字符,但是不移动指针
-func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+func (l *Lexer) peekChar() rune {
+	return l.peekCharAt(1)
+}
+
+// 向前查看第n个字符,但是不移动指针
+func (l *Lexer) peekCharAt(n int) rune {
+	position := l.readPosition
+	for ; n > 1 && position < len(l.input); n-- {
+		_, width := utf8.DecodeRuneInString(l.input[position:])
+		position += width
+	}
+	if position >= len(l.input) {
 		return 0
-	} else {
-		return l.input[l.readPosition]
 	}
+	ch, _ := utf8.DecodeRuneInString(l.input[position:])
+	return ch
 }
 
-func (l *Lexer) readString() string {
+// 读取注释内容
+func (l *Lexer) readComment() string {
 	position := l.position + 1
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
+		if l.ch == '\n' || l.ch == 0 {
 			break
 		}
 	}
 	return l.input[position:l.position]
 }
 
-// 读取注释内容
-func (l *Lexer) readComment() string {
+// 读取块注释 /* ... */,调用时l.ch为开头的/,结束时l.ch为结尾的/
+func (l *Lexer) readBlockComment(pos token.Position) token.Token {
+	l.readChar() // 跳过 /
 	position := l.position + 1
 	for {
 		l.readChar()
-		if l.ch == '\n' || l.ch == 0 {
+		if l.ch == 0 {
+			return token.Token{Type: token.INVALID, Literal: "unterminated block comment", Pos: pos}
+		}
+		if l.ch == '*' && l.peekChar() == '/' {
 			break
 		}
 	}
-	return l.input[position:l.position]
+	literal := l.input[position:l.position]
+	l.readChar() // 跳过 *
+	return token.Token{Type: token.COMMENT, Literal: literal, Pos: pos}
 }
 
 // 根据当前的ch创建词法单元
@@ -116,20 +201,23 @@ func (l *Lexer) NextToken() token.Token {
 	// 跳过空格
 	l.skipWhitespace()
 
+	// 记录词法单元的起始位置
+	tok.Pos = l.pos()
+
 	switch l.ch {
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readString(tok.Pos)
+	case '`':
+		tok = l.readRawString(tok.Pos)
 	case '&':
 		if l.peekChar() == '&' {
 			// 记录当前ch (&)
 			ch := l.ch
 			l.readChar()
 			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.AND, Literal: literal}
+			tok = token.Token{Type: token.AND, Literal: literal, Pos: tok.Pos}
 		} else {
-			// 未知符合 &
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = l.newToken(token.BIT_AND, l.ch)
 		}
 	case '|':
 		if l.peekChar() == '|' {
@@ -137,10 +225,9 @@ func (l *Lexer) NextToken() token.Token {
 			ch := l.ch
 			l.readChar()
 			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.OR, Literal: literal}
+			tok = token.Token{Type: token.OR, Literal: literal, Pos: tok.Pos}
 		} else {
-			// 未知符号 |
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = l.newToken(token.BIT_OR, l.ch)
 		}
 	case '=':
 		if l.peekChar() == '=' {
@@ -148,56 +235,106 @@ func (l *Lexer) NextToken() token.Token {
 			ch := l.ch
 			l.readChar()
 			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.EQ, Literal: literal}
+			tok = token.Token{Type: token.EQ, Literal: literal, Pos: tok.Pos}
+		} else if l.peekChar() == '>' {
+			tok = l.newTwoCharToken(token.ARROW)
 		} else {
-			tok = newToken(token.ASSIGN, l.ch)
+			tok = l.newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.PLUS_ASSIGN)
+		} else {
+			tok = l.newToken(token.PLUS, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.MINUS_ASSIGN)
+		} else {
+			tok = l.newToken(token.MINUS, l.ch)
+		}
 	case '!':
 		if l.peekChar() == '=' {
 			// 记录当前ch (!)
 			ch := l.ch
 			l.readChar()
 			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.NOT_EQ, Literal: literal}
+			tok = token.Token{Type: token.NOT_EQ, Literal: literal, Pos: tok.Pos}
 		} else {
-			tok = newToken(token.BANG, l.ch)
+			tok = l.newToken(token.BANG, l.ch)
 		}
 	case '/':
-		if l.peekChar() == '/' {
+		if l.peekChar() == '/' && l.peekCharAt(2) == '/' && l.peekCharAt(3) != '/' {
+			// 文档注释 ///, 而 //// 是普通注释
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.DOC_COMMENT, Literal: l.readComment(), Pos: tok.Pos}
+		} else if l.peekChar() == '/' {
 			l.readChar()               // 跳过 /
 			literal := l.readComment() // 读取注释内容
-			tok = token.Token{Type: token.COMMENT, Literal: literal}
+			tok = token.Token{Type: token.COMMENT, Literal: literal, Pos: tok.Pos}
+		} else if l.peekChar() == '*' {
+			tok = l.readBlockComment(tok.Pos)
+		} else if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.SLASH_ASSIGN)
 		} else {
-			tok = newToken(token.SLASH, l.ch)
+			tok = l.newToken(token.SLASH, l.ch)
 		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '*' {
+			tok = l.newTwoCharToken(token.POWER)
+		} else if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.ASTERISK_ASSIGN)
+		} else {
+			tok = l.newToken(token.ASTERISK, l.ch)
+		}
+	case '%':
+		tok = l.newToken(token.PERCENT, l.ch)
+	case '^':
+		tok = l.newToken(token.BIT_XOR, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.newTwoCharToken(token.LT_EQ)
+		case '<':
+			tok = l.newTwoCharToken(token.SHL)
+		default:
+			tok = l.newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.newTwoCharToken(token.GT_EQ)
+		case '>':
+			tok = l.newTwoCharToken(token.SHR)
+		default:
+			tok = l.newToken(token.GT, l.ch)
+		}
 	case ';':
-		tok = newToken(token.SEMICOLON, l.ch)
+		tok = l.newToken(token.SEMICOLON, l.ch)
 	case ':':
-		tok = newToken(token.COLON, l.ch)
+		tok = l.newToken(token.COLON, l.ch)
+	case '.':
+		// .5 是小数,其他情况是成员访问 s.split
+		if isDigit(l.peekChar()) {
+			tok.Type, tok.Literal = l.readNumber()
+			return tok
+		}
+		tok = l.newToken(token.DOT, l.ch)
 	case '(':
-		tok = newToken(token.LPAREN, l.ch)
+		tok = l.newToken(token.LPAREN, l.ch)
 	case ')':
-		tok = newToken(token.RPAREN, l.ch)
+		tok = l.newToken(token.RPAREN, l.ch)
 	case ',':
-		tok = newToken(token.COMMA, l.ch)
+		tok = l.newToken(token.COMMA, l.ch)
 	case '{':
-		tok = newToken(token.LBRACE, l.ch)
+		tok = l.newToken(token.LBRACE, l.ch)
 	case '}':
-		tok = newToken(token.RBRACE, l.ch)
+		tok = l.newToken(token.RBRACE, l.ch)
 	case '[':
-		tok = newToken(token.LBRACKET, l.ch)
+		tok = l.newToken(token.LBRACKET, l.ch)
 	case ']':
-		tok = newToken(token.RBRACKET, l.ch)
+		tok = l.newToken(token.RBRACKET, l.ch)
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -208,11 +345,10 @@ func (l *Lexer) NextToken() token.Token {
 			// 因为readIdentifier会调用readChar,所以提前return,不需要后面再readChar
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Type, tok.Literal = l.readNumber()
 			return tok
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = l.newToken(token.ILLEGAL, l.ch)
 		}
 	}
 
@@ -17,7 +17,7 @@ func TestNextToken(t *testing.T) {
 	};
 
 	let result = add(five, ten);
-	!-/*5;
+	!-/ *5;
 	5 < 10 > 5;
 
 	if (5 < 10) {
@@ -34,6 +34,7 @@ func TestNextToken(t *testing.T) {
 	[1,2];
 
 	{"foo": "bar"}
+	macro(x, y) { x + y; };
 	`
 
 	tests := []struct {
@@ -114,7 +115,7 @@ func TestNextToken(t *testing.T) {
 		{token.INT, "9"},
 		{token.SEMICOLON, ";"},
 		{token.STRING, "foobar0"},
-		{token.STRING, "\\nfoo bar"},
+		{token.STRING, "\nfoo bar"},
 		{token.LBRACKET, "["},
 		{token.INT, "1"},
 		{token.COMMA, ","},
@@ -126,6 +127,19 @@ func TestNextToken(t *testing.T) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		{token.MACRO, "macro"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.COMMA, ","},
+		{token.IDENT, "y"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "x"},
+		{token.PLUS, "+"},
+		{token.IDENT, "y"},
+		{token.SEMICOLON, ";"},
+		{token.RBRACE, "}"},
+		{token.SEMICOLON, ";"},
 		{token.EOF, ""},
 	}
 
@@ -145,3 +159,279 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPosition(t *testing.T) {
+	input := "let x = 5;\n  x + \"ab\";\n"
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+	}{
+		{token.LET, 1, 1},
+		{token.IDENT, 1, 5},
+		{token.ASSIGN, 1, 7},
+		{token.INT, 1, 9},
+		{token.SEMICOLON, 1, 10},
+		{token.IDENT, 2, 3},
+		{token.PLUS, 2, 5},
+		{token.STRING, 2, 7},
+		{token.SEMICOLON, 2, 11},
+		{token.EOF, 3, 1},
+	}
+
+	l := NewWithFile("test.mal", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos.File != "test.mal" {
+			t.Fatalf("tests[%d] - file wrong. got=%q", i, tok.Pos.File)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := `let 总数 = 1; // 注释:总数
+	"你好, 世界" café_名字 ，`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedColumn  int
+	}{
+		{token.LET, "let", 1},
+		{token.IDENT, "总数", 5},
+		{token.ASSIGN, "=", 8},
+		{token.INT, "1", 10},
+		{token.SEMICOLON, ";", 11},
+		{token.COMMENT, " 注释:总数", 13},
+		{token.STRING, "你好, 世界", 2},
+		{token.IDENT, "café_名字", 11},
+		{token.ILLEGAL, "，", 19},
+		{token.EOF, "", 20},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - column wrong. expected=%d, got=%d",
+				i, tt.expectedColumn, tok.Pos.Column)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{`"a\nb\tc"`, token.STRING, "a\nb\tc"},
+		{`"say \"hi\""`, token.STRING, `say "hi"`},
+		{`"back\\slash"`, token.STRING, `back\slash`},
+		{`"\u{4F60}\u{597D}"`, token.STRING, "你好"},
+		{`"\${x}"`, token.STRING, "${x}"},
+		{"`raw\\n\n\"line\"`", token.STRING, "raw\\n\n\"line\""},
+		{`"total: ${sum(xs)}"`, token.TEMPLATE, "total: ${sum(xs)}"},
+		{`"${ {"a": "}"}["a"] }"`, token.TEMPLATE, `${ {"a": "}"}["a"] }`},
+		{`"abc`, token.INVALID, "unterminated string"},
+		{`"abc\"`, token.INVALID, "unterminated string"},
+		{`"${x"`, token.INVALID, "unterminated string"},
+		{"`abc", token.INVALID, "unterminated raw string"},
+		{`"\q"`, token.INVALID, `invalid escape sequence \q`},
+		{`"\u{zz}"`, token.INVALID, `invalid unicode escape \u{zz}`},
+	}
+
+	for i, tt := range tests {
+		tok := New(tt.input).NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestSplitTemplate(t *testing.T) {
+	tok := New(`"a\t${x + 1}b${ f("}") }"`).NextToken()
+
+	parts, err := SplitTemplate(tok)
+	if err != nil {
+		t.Fatalf("SplitTemplate returned error: %s", err)
+	}
+
+	expected := []TemplatePart{
+		{Literal: "a\t", Pos: token.Position{Line: 1, Column: 2}},
+		{Literal: "x + 1", IsExpr: true, Pos: token.Position{Line: 1, Column: 7}},
+		{Literal: "b", Pos: token.Position{Line: 1, Column: 13}},
+		{Literal: ` f("}") `, IsExpr: true, Pos: token.Position{Line: 1, Column: 16}},
+	}
+	if len(parts) != len(expected) {
+		t.Fatalf("wrong number of parts. want=%d, got=%d (%+v)", len(expected), len(parts), parts)
+	}
+	for i, part := range parts {
+		if part != expected[i] {
+			t.Errorf("parts[%d] wrong. want=%+v, got=%+v", i, expected[i], part)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `5 3.14 .5 1e-9 2.5E+3 10e2 7. 1e x.5`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, ".5"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E+3"},
+		{token.FLOAT, "10e2"},
+		{token.INT, "7"},
+		{token.DOT, "."},
+		{token.INT, "1"},
+		{token.IDENT, "e"},
+		{token.IDENT, "x"},
+		{token.FLOAT, ".5"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	input := `<= >= < > % ** * & && | || ^ << >> = += -= *= /= => .`
+
+	tests := []token.TokenType{
+		token.LT_EQ, token.GT_EQ, token.LT, token.GT, token.PERCENT,
+		token.POWER, token.ASTERISK, token.BIT_AND, token.AND, token.BIT_OR,
+		token.OR, token.BIT_XOR, token.SHL, token.SHR, token.ASSIGN,
+		token.PLUS_ASSIGN, token.MINUS_ASSIGN, token.ASTERISK_ASSIGN,
+		token.SLASH_ASSIGN, token.ARROW, token.DOT, token.EOF,
+	}
+
+	l := New(input)
+
+	for i, expectedType := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, expectedType, tok.Type)
+		}
+		if expectedType != token.EOF && tok.Literal != string(expectedType) {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, expectedType, tok.Literal)
+		}
+	}
+}
+
+func TestIdentifierSuffix(t *testing.T) {
+	input := `append!(a, 1) a!=b !x x1 1x`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "append!"},
+		{token.LPAREN, "("},
+		{token.IDENT, "a"},
+		{token.COMMA, ","},
+		{token.INT, "1"},
+		{token.RPAREN, ")"},
+		{token.IDENT, "a"},
+		{token.NOT_EQ, "!="},
+		{token.IDENT, "b"},
+		{token.BANG, "!"},
+		{token.IDENT, "x"},
+		{token.IDENT, "x1"},
+		{token.INT, "1"},
+		{token.IDENT, "x"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q(%q), got=%q(%q)",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// line
+/// doc
+//// not doc
+a /* block
+comment */ b /**/
+/* open`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.COMMENT, " line"},
+		{token.DOC_COMMENT, " doc"},
+		{token.COMMENT, "// not doc"},
+		{token.IDENT, "a"},
+		{token.COMMENT, " block\ncomment "},
+		{token.IDENT, "b"},
+		{token.COMMENT, ""},
+		{token.INVALID, "unterminated block comment"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q(%q), got=%q(%q)",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
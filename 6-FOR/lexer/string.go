@@ -0,0 +1,209 @@
+package lexer
+
+import (
+	"fmt"
+	"malang/token"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 读取双引号字符串,支持转义和${}插值
+func (l *Lexer) readString(pos token.Position) token.Token {
+	raw, interpolated, ok := l.scanString()
+	if !ok {
+		return token.Token{Type: token.INVALID, Literal: "unterminated string", Pos: pos}
+	}
+	// 含有插值的字符串交给parser拆分
+	if interpolated {
+		return token.Token{Type: token.TEMPLATE, Literal: raw, Pos: pos}
+	}
+	value, err := Unescape(raw)
+	if err != nil {
+		return token.Token{Type: token.INVALID, Literal: err.Error(), Pos: pos}
+	}
+	return token.Token{Type: token.STRING, Literal: value, Pos: pos}
+}
+
+// 读取反引号原始字符串(不处理转义,可以跨行)
+func (l *Lexer) readRawString(pos token.Position) token.Token {
+	position := l.position + 1
+	for {
+		l.readChar()
+		if l.ch == 0 {
+			return token.Token{Type: token.INVALID, Literal: "unterminated raw string", Pos: pos}
+		}
+		if l.ch == '`' {
+			break
+		}
+	}
+	return token.Token{Type: token.STRING, Literal: l.input[position:l.position], Pos: pos}
+}
+
+// 扫描字符串的原始内容,调用时l.ch为开头的",结束时l.ch为结尾的"
+// 返回: 原始内容, 是否包含插值, 是否正常闭合
+func (l *Lexer) scanString() (string, bool, bool) {
+	position := l.position + 1
+	interpolated := false
+	for {
+		l.readChar()
+		switch l.ch {
+		case 0:
+			return l.input[position:l.position], interpolated, false
+		case '\\':
+			// 跳过被转义的字符,具体含义由Unescape处理
+			l.readChar()
+			if l.ch == 0 {
+				return l.input[position:l.position], interpolated, false
+			}
+		case '"':
+			return l.input[position:l.position], interpolated, true
+		case '$':
+			if l.peekChar() == '{' {
+				l.readChar()
+				interpolated = true
+				if !l.skipInterpolation() {
+					return l.input[position:l.position], interpolated, false
+				}
+			}
+		}
+	}
+}
+
+// 跳过插值表达式,调用时l.ch为${的{,结束时l.ch为匹配的}
+func (l *Lexer) skipInterpolation() bool {
+	depth := 1
+	for {
+		l.readChar()
+		switch l.ch {
+		case 0:
+			return false
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return true
+			}
+		case '"':
+			// 表达式中可以嵌套字符串
+			if _, _, ok := l.scanString(); !ok {
+				return false
+			}
+		case '`':
+			if tok := l.readRawString(l.pos()); tok.Type == token.INVALID {
+				return false
+			}
+		}
+	}
+}
+
+// 插值字符串的片段
+type TemplatePart struct {
+	Literal string         // 文本片段(已处理转义)或表达式源码
+	IsExpr  bool           // 是否是${}中的表达式
+	Pos     token.Position // 片段在源码中的位置
+}
+
+// 将TEMPLATE词法单元拆分为文本和表达式片段
+func SplitTemplate(tok token.Token) ([]TemplatePart, error) {
+	// 原始内容从开头的"之后开始
+	start := tok.Pos
+	start.Column++
+	l := NewAt(start, tok.Literal)
+
+	parts := []TemplatePart{}
+	textStart, textPos := 0, l.pos()
+
+	// 结束当前的文本片段
+	flush := func() error {
+		if l.position == textStart {
+			return nil
+		}
+		text, err := Unescape(l.input[textStart:l.position])
+		if err != nil {
+			return err
+		}
+		parts = append(parts, TemplatePart{Literal: text, Pos: textPos})
+		return nil
+	}
+
+	for l.ch != 0 {
+		switch {
+		case l.ch == '\\':
+			l.readChar()
+		case l.ch == '$' && l.peekChar() == '{':
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			l.readChar() // {
+			exprStart, exprPos := l.readPosition, l.pos()
+			exprPos.Column++
+			if !l.skipInterpolation() {
+				return nil, fmt.Errorf("unterminated string interpolation")
+			}
+			parts = append(parts, TemplatePart{
+				Literal: l.input[exprStart:l.position],
+				IsExpr:  true,
+				Pos:     exprPos,
+			})
+			l.readChar() // }
+			textStart, textPos = l.position, l.pos()
+			continue
+		}
+		l.readChar()
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return parts, nil
+}
+
+// 处理字符串中的转义序列: \n \t \r \" \\ \$ \0 \u{...}
+func Unescape(s string) (string, error) {
+	if !strings.ContainsRune(s, '\\') {
+		return s, nil
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return "", fmt.Errorf("invalid escape sequence at end of string")
+		}
+		switch s[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case 'r':
+			out.WriteByte('\r')
+		case '0':
+			out.WriteByte(0)
+		case '"', '\\', '$', '`':
+			out.WriteByte(s[i])
+		case 'u':
+			// \u{4F60}
+			end := strings.IndexByte(s[i:], '}')
+			if i+1 >= len(s) || s[i+1] != '{' || end < 0 {
+				return "", fmt.Errorf("invalid unicode escape, expected \\u{...}")
+			}
+			hex := s[i+2 : i+end]
+			code, err := strconv.ParseUint(hex, 16, 32)
+			if err != nil || hex == "" || !utf8.ValidRune(rune(code)) {
+				return "", fmt.Errorf("invalid unicode escape \\u{%s}", hex)
+			}
+			out.WriteRune(rune(code))
+			i += end
+		default:
+			ch, _ := utf8.DecodeRuneInString(s[i:])
+			return "", fmt.Errorf("invalid escape sequence \\%c", ch)
+		}
+	}
+	return out.String(), nil
+}
@@ -6,14 +6,18 @@ import (
 	"fmt"
 	"io/ioutil"
 	"malang/repl"
+	"malang/util"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 )
 
 type Cmd struct {
 	helpFlag    bool
 	versionFlag bool
 	replFlag    bool // 控制台程序
+	vmFlag      bool // 使用虚拟机执行
 	cpOption    string
 	malFile     string // 待编译的文件
 	args        []string
@@ -21,6 +25,8 @@ type Cmd struct {
 
 func printUsage() {
 	fmt.Printf("Usage: %s [-options] [args...]\n", os.Args[0])
+	fmt.Printf("       %s compile [-o out.malc] [-nodebug] file.mal\n", os.Args[0])
+	fmt.Printf("       %s run [-vm] file.mal|file.malc\n", os.Args[0])
 }
 func parseCmd() *Cmd {
 	cmd := &Cmd{}
@@ -28,6 +34,7 @@ func parseCmd() *Cmd {
 	flag.BoolVar(&cmd.helpFlag, "help", false, "print help message")
 	flag.BoolVar(&cmd.helpFlag, "?", false, "print help message")
 	flag.BoolVar(&cmd.replFlag, "repl", false, "repl")
+	flag.BoolVar(&cmd.vmFlag, "vm", false, "run with the bytecode vm")
 	flag.BoolVar(&cmd.versionFlag, "v", false, "print version and exit")
 	flag.StringVar(&cmd.cpOption, "filepath", "", "filepath")
 	flag.StringVar(&cmd.cpOption, "f", "", "filepath")
@@ -48,21 +55,83 @@ func main() {
 	}
 	fmt.Printf("Hello %s! This is the Malang programming language!\n", user.Username)
 	fmt.Printf("Feel free to type in commands\n")
+	// 子命令: ./malang compile 1.mal, ./malang run 1.malc
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "compile":
+			compileCmd(os.Args[2:])
+			return
+		case "run":
+			runCmd(os.Args[2:])
+			return
+		}
+	}
 	cmd := parseCmd()
 	if cmd.versionFlag {
 		fmt.Println("version: 0.0.1 by malred 2023.6.6")
 	} else if cmd.helpFlag {
 		printUsage()
+	} else if cmd.replFlag && cmd.vmFlag {
+		repl.StartVM(os.Stdin, os.Stdout)
 	} else if cmd.replFlag {
 		repl.Start(os.Stdin, os.Stdout)
 	} else {
 		// 读取-f指定的文件
-		fmt.Println("reading: ", cmd.cpOption)
-		buf, err := ioutil.ReadFile(cmd.cpOption)
-		if err != nil {
-			panic(err)
-		}
-		input := string(buf)
-		repl.ReadAndEval(input)
+		runFile(cmd.cpOption, cmd.vmFlag)
+	}
+}
+
+// 运行源文件或编译后的.malc文件
+func runFile(fileName string, vm bool) {
+	if filepath.Ext(fileName) == util.MALC_EXT {
+		repl.RunCompiled(fileName)
+		return
+	}
+
+	fmt.Println("reading: ", fileName)
+	buf, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		panic(err)
+	}
+	input := string(buf)
+	if vm {
+		repl.ReadAndRunVM(fileName, input)
+	} else {
+		repl.ReadAndEval(fileName, input)
+	}
+}
+
+// 编译为.malc文件,默认和源文件同名
+func compileCmd(args []string) {
+	flags := flag.NewFlagSet("compile", flag.ExitOnError)
+	output := flags.String("o", "", "output file")
+	noDebug := flags.Bool("nodebug", false, "omit source positions from the output")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		printUsage()
+		return
+	}
+
+	fileName := flags.Arg(0)
+	buf, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	outName := *output
+	if outName == "" {
+		outName = strings.TrimSuffix(fileName, filepath.Ext(fileName)) + util.MALC_EXT
+	}
+	repl.CompileFile(fileName, string(buf), outName, !*noDebug)
+}
+
+func runCmd(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	vm := flags.Bool("vm", false, "run source files with the bytecode vm")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		printUsage()
+		return
 	}
+	runFile(flags.Arg(0), *vm)
 }
@@ -31,3 +31,34 @@ func (e *Environment) Set(name string, value Object) Object {
 	e.store[name] = value
 	return value
 }
+
+// 更新已存在的变量,沿着outer向外查找定义该变量的环境
+// 变量未定义时返回false
+func (e *Environment) Assign(name string, value Object) (Object, bool) {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = value
+		return value, true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, value)
+	}
+	return nil, false
+}
+
+// 复制环境中的绑定,修改副本不影响原来的环境
+func (e *Environment) Copy() *Environment {
+	env := NewEnvironment()
+	env.outer = e.outer
+	for name, value := range e.store {
+		env.store[name] = value
+	}
+	return env
+}
+
+// 最外层(全局)环境
+func (e *Environment) Root() *Environment {
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e
+}
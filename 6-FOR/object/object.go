@@ -6,6 +6,11 @@ import (
 	"fmt"
 	"hash/fnv"
 	"malang/ast"
+	"malang/code"
+	"malang/token"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -14,6 +19,7 @@ type ObjectType string
 // 对象类型常量
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
@@ -27,6 +33,9 @@ const (
 	HASH_OBJ         = "HASH"
 	QUOTE_OBJ        = "QUOTE"
 	MACRO_OBJ        = "MACRO"
+	MODULE_OBJ       = "MODULE"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
 )
 
 type Object interface {
@@ -41,6 +50,22 @@ type Integer struct {
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Inspect() string  { return FormatFloat(f.Value) }
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// 格式化浮点数,保证结果看起来不像整数(3 -> 3.0)
+func FormatFloat(v float64) string {
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -62,10 +87,16 @@ func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
 type Error struct {
 	Message string
+	Pos     token.Position // 出错的源码位置
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
 
 type Function struct {
 	Parameters []*ast.Identifier
@@ -75,10 +106,15 @@ type Function struct {
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 func (f *Function) Inspect() string {
+	return FunctionSource(f.Parameters, f.Body)
+}
+
+// 函数的源码表示,解释器和虚拟机中的函数显示相同的内容
+func FunctionSource(parameters []*ast.Identifier, body *ast.BlockStatement) string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range f.Parameters {
+	for _, p := range parameters {
 		params = append(params, p.String())
 	}
 
@@ -86,12 +122,43 @@ func (f *Function) Inspect() string {
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") {\n")
-	out.WriteString(f.Body.String())
+	out.WriteString(body.String())
 	out.WriteString("\n}")
 
 	return out.String()
 }
 
+// 编译后的函数,保存在常量池中
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int // 局部绑定的个数(包括参数)
+	NumParameters int
+	Lines         code.LineTable // 调试信息,可能为空
+	Source        string         // 函数的源码表示,可能为空
+	LocalNames    []string       // 局部绑定的名字,下标是局部绑定的下标,调试信息,可能为空
+	FreeNames     []string       // 自由变量的名字,调试信息,可能为空
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// 虚拟机中的函数值: 编译后的函数和它捕获的自由变量
+// 类型和解释器中的函数一样是FUNCTION,报错信息保持一致
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	if c.Fn.Source != "" {
+		return c.Fn.Source
+	}
+	return fmt.Sprintf("fn(<%d params>)", c.Fn.NumParameters)
+}
+
 type String struct {
 	Value string
 }
@@ -106,7 +173,7 @@ type Builtin struct {
 	Fn BuiltinFunction
 }
 
-func (b *Builtin) Type() ObjectType { return BOOLEAN_OBJ }
+func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function" }
 
 type Array struct {
@@ -153,6 +220,14 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+func (f *Float) HashKey() HashKey {
+	// 0.0和-0.0相等,使用同一个key
+	if f.Value == 0 {
+		return HashKey{Type: f.Type(), Value: 0}
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -174,8 +249,8 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprint("%s: %s", pair.Key, pair.Value.Inspect()))
+	for _, pair := range h.SortedPairs() {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
 	}
 
 	out.WriteString("{")
@@ -185,6 +260,36 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// 按键排序后的键值对,保证遍历和输出的顺序稳定
+// 不同类型的键按类型名排序,同类型的键按值排序
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return lessKey(pairs[i].Key, pairs[j].Key)
+	})
+	return pairs
+}
+
+func lessKey(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+	switch a := a.(type) {
+	case *Integer:
+		return a.Value < b.(*Integer).Value
+	case *Float:
+		return a.Value < b.(*Float).Value
+	case *String:
+		return a.Value < b.(*String).Value
+	case *Boolean:
+		return !a.Value && b.(*Boolean).Value
+	}
+	return a.Inspect() < b.Inspect()
+}
+
 type Quote struct {
 	Node ast.Node
 }
@@ -196,6 +301,7 @@ type Macro struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Hygienic   bool // 展开时重命名宏引入的绑定
 }
 
 func (m *Macro) Type() ObjectType { return MACRO_OBJ }
@@ -207,6 +313,9 @@ func (m *Macro) Inspect() string {
 		params = append(params, p.String())
 	}
 
+	if m.Hygienic {
+		out.WriteString("hygienic ")
+	}
 	out.WriteString("macro")
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
@@ -228,3 +337,31 @@ type Break struct {
 
 func (b *Break) Type() ObjectType { return BREAK }
 func (b *Break) Inspect() string  { return "break" }
+
+// use导入的模块,每个模块有自己的环境
+type Module struct {
+	Name    string          // 默认的绑定名(文件名去掉扩展名)
+	Path    string          // 模块文件路径
+	Env     *Environment    // 模块顶层定义所在的环境
+	Exports map[string]bool // export let导出的名字,为nil时导出所有非_开头的名字
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return "module(" + m.Name + ")" }
+
+// 获取导出的成员
+func (m *Module) Get(name string) (Object, bool) {
+	if !m.Exported(name) {
+		return nil, false
+	}
+	obj, ok := m.Env.store[name]
+	return obj, ok
+}
+
+// 名字是否导出,_开头的名字和没有export的名字(模块使用了export时)是私有的
+func (m *Module) Exported(name string) bool {
+	if strings.HasPrefix(name, "_") {
+		return false
+	}
+	return m.Exports == nil || m.Exports[name]
+}
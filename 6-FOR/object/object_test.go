@@ -19,4 +19,63 @@ func TestStringHashKey(t *testing.T){
 	if hello1.HashKey() == diff1.HashKey(){
 		t.Errorf("strings with different content have same hash keys")
 	}
-}
\ No newline at end of file
+}
+
+func TestFloatHashKey(t *testing.T) {
+	a := &Float{Value: 2.5}
+	b := &Float{Value: 2.5}
+	c := &Float{Value: 3.5}
+
+	if a.HashKey() != b.HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+	if a.HashKey() == c.HashKey() {
+		t.Errorf("floats with different values have same hash keys")
+	}
+	if (&Float{Value: 0}).HashKey() != (&Float{Value: -1 * 0.0}).HashKey() {
+		t.Errorf("0.0 and -0.0 have different hash keys")
+	}
+	if (&Float{Value: 1}).HashKey() == (&Integer{Value: 1}).HashKey() {
+		t.Errorf("float and integer have same hash keys")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := map[float64]string{
+		3:      "3.0",
+		3.14:   "3.14",
+		-0.5:   "-0.5",
+		1e-9:   "1e-09",
+		1e21:   "1e+21",
+		100000: "100000.0",
+	}
+	for value, expected := range tests {
+		if got := (&Float{Value: value}).Inspect(); got != expected {
+			t.Errorf("Inspect wrong. want=%q, got=%q", expected, got)
+		}
+	}
+}
+
+func TestHashSortedPairs(t *testing.T) {
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	for _, key := range []Hashable{
+		&String{Value: "b"}, &Integer{Value: 10}, &String{Value: "a"},
+		&Integer{Value: -2}, &Boolean{Value: true},
+	} {
+		hash.Pairs[key.HashKey()] = HashPair{Key: key.(Object), Value: key.(Object)}
+	}
+
+	expected := "{true: true, -2: -2, 10: 10, a: a, b: b}"
+	for i := 0; i < 5; i++ {
+		if got := hash.Inspect(); got != expected {
+			t.Fatalf("Inspect wrong. want=%q, got=%q", expected, got)
+		}
+	}
+}
+
+func TestClosureInspectWithoutSource(t *testing.T) {
+	cl := &Closure{Fn: &CompiledFunction{NumParameters: 2}}
+	if got := cl.Inspect(); got != "fn(<2 params>)" {
+		t.Errorf("Inspect wrong. want=%q, got=%q", "fn(<2 params>)", got)
+	}
+}
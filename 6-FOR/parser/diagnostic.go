@@ -0,0 +1,52 @@
+// parser/diagnostic.go
+package parser
+
+import (
+	"fmt"
+	"malang/token"
+)
+
+// 诊断信息的严重程度
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+// 诊断代码,便于工具按类型处理错误
+const (
+	CodeUnexpectedToken = "P001" // 下一个词法单元不是期望的类型
+	CodeNoPrefix        = "P002" // 无法作为表达式开头的词法单元
+	CodeInvalidNumber   = "P003" // 数字字面量无法解析
+	CodeLexical         = "P004" // 词法错误: 非法字符、未闭合的字符串等
+	CodeInvalidTarget   = "P005" // 非法的赋值对象
+	CodeInvalidPattern  = "P006" // 非法的match模式
+	CodeInterpolation   = "P007" // 字符串插值中的错误
+)
+
+// 结构化的诊断信息
+type Diagnostic struct {
+	Severity Severity
+	Pos      token.Position
+	Code     string
+	Message  string
+	Expected string // 期望的词法单元,没有时为空
+	Found    string // 实际遇到的词法单元,没有时为空
+}
+
+// 格式: 文件:行:列: 信息
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
@@ -6,37 +6,60 @@ import (
 	"malang/ast"
 	"malang/lexer"
 	"malang/token"
+	"path"
 	"strconv"
+	"strings"
 )
 
 const (
 	_ int = iota // 0
 	LOWEST
+	ASSIGN     // = += -= *= /=
 	OR         // ||
 	AND        // &&
 	EQUALS     // ==
 	LESSGEATER // > or <
+	BITOR      // |
+	BITXOR     // ^
+	BITAND     // &
+	SHIFT      // << or >>
 	SUM        // +
-	PRODUCT    // *
+	PRODUCT    // * or / or %
 	PREFIX     // -X or !X
+	POWER      // X ** Y (右结合,-2 ** 2 == -(2 ** 2))
 	CALL       // myFunction(X)
 	INDEX      // array[index]
 )
 
 // 优先级map
 var precedences = map[token.TokenType]int{
-	token.OR:       OR,
-	token.AND:      AND,
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGEATER,
-	token.GT:       LESSGEATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.OR:              OR,
+	token.AND:             AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGEATER,
+	token.GT:              LESSGEATER,
+	token.LT_EQ:           LESSGEATER,
+	token.GT_EQ:           LESSGEATER,
+	token.BIT_OR:          BITOR,
+	token.BIT_XOR:         BITXOR,
+	token.BIT_AND:         BITAND,
+	token.SHL:             SHIFT,
+	token.SHR:             SHIFT,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.POWER:           POWER,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
+	token.DOT:             INDEX,
 }
 
 type (
@@ -47,9 +70,17 @@ type (
 type Parser struct {
 	l *lexer.Lexer
 
-	errors    []string
+	diagnostics []*Diagnostic
+	// 出错后进入panic模式,直到同步到语句边界前不再记录新的错误
+	panicking bool
+	// 当前未闭合的{数量,用于错误恢复时判断块的边界
+	depth int
+
 	curToken  token.Token
 	peekToken token.Token
+	// 紧挨在curToken/peekToken前面的文档注释
+	curDoc  []string
+	peekDoc []string
 
 	// 解析函数
 	prefixParseFns map[token.TokenType]prefixParseFn
@@ -93,8 +124,22 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorf(CodeInvalidNumber, p.curToken.Pos, "could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+// 解析函数-浮点数字面量-前缀
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.errorf(CodeInvalidNumber, p.curToken.Pos, "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 
@@ -128,6 +173,10 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 
 	// 查找不到优先级不会当作中缀表达式进行解析,而是作为前缀
 	precedence := p.curPrecedence()
+	// 乘方是右结合的: 2 ** 3 ** 2 == 2 ** (3 ** 2)
+	if p.curTokenIs(token.POWER) {
+		precedence--
+	}
 	// 进入下一个词法单元然后填充Right
 	p.nextToken()
 	// 解析下一个表达式,并传入优先级
@@ -136,11 +185,44 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// 解析函数-赋值表达式-中缀
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	exp := &ast.AssignExpression{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: p.curToken.Literal,
+	}
+
+	switch target := target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	case *ast.CallExpression:
+		// quote中的 unquote(x) = 1
+		if target.Function.TokenLiteral() != "unquote" {
+			p.errorf(CodeInvalidTarget, p.curToken.Pos, "invalid assignment target: %s", target)
+			return nil
+		}
+	default:
+		p.errorf(CodeInvalidTarget, p.curToken.Pos, "invalid assignment target: %s", target)
+		return nil
+	}
+
+	p.nextToken()
+	// 赋值是右结合的: a = b = 1
+	exp.Value = p.parseExpression(ASSIGN - 1)
+
+	return exp
+}
+
 // 解析函数-布尔字面量-前缀
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
 
+// 解析函数-空值字面量-前缀
+func (p *Parser) parseNullLiteral() ast.Expression {
+	return &ast.NullLiteral{Token: p.curToken}
+}
+
 // 解析函数-分组表达式(括号)-前缀
 func (p *Parser) parseGroupedExpression() ast.Expression {
 	p.nextToken()
@@ -158,13 +240,18 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
+	depth := p.depth
 
 	p.nextToken()
 
 	// !}
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.panicking {
+			if p.synchronize(depth) {
+				break
+			}
+		} else if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		p.nextToken()
@@ -202,6 +289,20 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
 
+		// else if (...) {}: 嵌套的if表达式作为else块中唯一的语句
+		if p.peekTokenIs(token.IF) {
+			p.nextToken()
+			expression.Alternative = &ast.BlockStatement{Token: p.curToken}
+			nested := p.parseIfExpression()
+			if nested == nil {
+				return nil
+			}
+			expression.Alternative.Statements = []ast.Statement{
+				&ast.ExpressionStatement{Token: expression.Alternative.Token, Expression: nested},
+			}
+			return expression
+		}
+
 		if !p.expectPeek(token.LBRACE) {
 			return nil
 		}
@@ -212,6 +313,116 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+// 解析函数-match表达式-前缀
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	// match(
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+
+	// match(){
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		expression.Arms = append(expression.Arms, arm)
+
+		// 分支之间用逗号分隔
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	return expression
+}
+
+// 解析match分支: pattern [if guard] => body
+// body为{}时解析为块语句,哈希表作为结果需要加括号
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	arm := &ast.MatchArm{Pattern: p.parseExpression(LOWEST)}
+	if arm.Pattern == nil {
+		return nil
+	}
+	if !p.checkPattern(arm.Pattern) {
+		return nil
+	}
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+	p.nextToken()
+
+	if p.curTokenIs(token.LBRACE) {
+		arm.Body = p.parseBlockStatement()
+	} else {
+		bodyToken := p.curToken
+		arm.Body = &ast.BlockStatement{Token: bodyToken, Statements: []ast.Statement{
+			&ast.ExpressionStatement{Token: bodyToken, Expression: p.parseExpression(LOWEST)},
+		}}
+	}
+	return arm
+}
+
+// 检查match的模式是否合法: 字面量、标识符、数组、哈希表
+func (p *Parser) checkPattern(pattern ast.Expression) bool {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier, *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.Boolean, *ast.NullLiteral:
+		return true
+	case *ast.PrefixExpression:
+		// 负数 -1
+		switch pattern.Right.(type) {
+		case *ast.IntegerLiteral, *ast.FloatLiteral:
+			if pattern.Operator == "-" {
+				return true
+			}
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range pattern.Elements {
+			if !p.checkPattern(el) {
+				return false
+			}
+		}
+		return true
+	case *ast.HashLiteral:
+		for key, value := range pattern.Pairs {
+			switch key.(type) {
+			case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+			default:
+				p.errorf(CodeInvalidPattern, key.Pos(), "invalid hash pattern key: %s", key)
+				return false
+			}
+			if !p.checkPattern(value) {
+				return false
+			}
+		}
+		return true
+	}
+	p.errorf(CodeInvalidPattern, pattern.Pos(), "invalid pattern: %s", pattern)
+	return false
+}
+
 // 解析函数参数列表
 func (p *Parser) parseFunctionParameter() []*ast.Identifier {
 	identifiers := []*ast.Identifier{}
@@ -224,15 +435,13 @@ func (p *Parser) parseFunctionParameter() []*ast.Identifier {
 
 	p.nextToken()
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+	identifiers = append(identifiers, p.parseBindingIdentifier())
 
 	// fn(arg1,
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken() // arg2
 		p.nextToken() // ,
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
+		identifiers = append(identifiers, p.parseBindingIdentifier())
 	}
 
 	// fn(arg1,arg2)
@@ -242,6 +451,24 @@ func (p *Parser) parseFunctionParameter() []*ast.Identifier {
 	return identifiers
 }
 
+// 解析被绑定的标识符(let的变量名、函数参数)
+// quote中可以用unquote(x)代替名字,如 let unquote(tmp) = 1
+func (p *Parser) parseBindingIdentifier() *ast.Identifier {
+	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if ident.Value != "unquote" || !p.peekTokenIs(token.LPAREN) {
+		return ident
+	}
+
+	p.nextToken()
+	args := p.parseExpressionList(token.RPAREN)
+	if len(args) != 1 {
+		p.errorf(CodeUnexpectedToken, ident.Pos(), "unquote takes exactly one argument. got=%d", len(args))
+		return ident
+	}
+	ident.Unquote = args[0]
+	return ident
+}
+
 // 解析函数-函数表达式-前缀
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
@@ -303,6 +530,55 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// 解析函数-插值字符串-前缀
+func (p *Parser) parseTemplateLiteral() ast.Expression {
+	tmpl := &ast.TemplateLiteral{Token: p.curToken}
+
+	parts, err := lexer.SplitTemplate(p.curToken)
+	if err != nil {
+		p.errorf(CodeLexical, p.curToken.Pos, "%s", err)
+		return nil
+	}
+
+	for _, part := range parts {
+		if !part.IsExpr {
+			tok := token.Token{Type: token.STRING, Literal: part.Literal, Pos: part.Pos}
+			tmpl.Parts = append(tmpl.Parts, &ast.StringLiteral{Token: tok, Value: part.Literal})
+			continue
+		}
+
+		// ${}中的表达式用新的parser解析,位置从插值处开始计算
+		sub := New(lexer.NewAt(part.Pos, part.Literal))
+		if sub.curTokenIs(token.EOF) {
+			p.errorf(CodeInterpolation, part.Pos, "empty string interpolation")
+			return nil
+		}
+		exp := sub.parseExpression(LOWEST)
+		if len(sub.diagnostics) == 0 && !sub.peekTokenIs(token.EOF) {
+			sub.errorf(CodeInterpolation, sub.peekToken.Pos, "unexpected %s in string interpolation", sub.peekToken.Type)
+		}
+		if len(sub.diagnostics) != 0 {
+			p.report(sub.diagnostics[0])
+			return nil
+		}
+		tmpl.Parts = append(tmpl.Parts, exp)
+	}
+
+	return tmpl
+}
+
+// 解析函数-词法错误-前缀
+func (p *Parser) parseInvalid() ast.Expression {
+	p.errorf(CodeLexical, p.curToken.Pos, "%s", p.curToken.Literal)
+	return nil
+}
+
+// 解析函数-未知字符-前缀
+func (p *Parser) parseIllegal() ast.Expression {
+	p.errorf(CodeLexical, p.curToken.Pos, "illegal character %q", p.curToken.Literal)
+	return nil
+}
+
 // 解析数组内的表达式
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	list := []ast.Expression{}
@@ -351,18 +627,68 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
-// 解析函数-注释-前缀
-func (p *Parser) parseCommentLiteral() ast.Expression {
-	return &ast.StringLiteral{Value: p.curToken.Literal}
+// 解析函数-成员访问-中缀
+func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
+	exp := &ast.MemberExpression{Token: p.curToken, Object: left}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Member = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	return exp
 }
 
 // 解析函数-导入-前缀
+// use std / use "lib/strings",没有扩展名时补上.mal
 func (p *Parser) parseUseLiteral() ast.Expression {
 	// 只需要导入,不需要求值
-	use := p.curToken
+	use := &ast.UseExpression{Token: p.curToken}
+	if !p.peekTokenIs(token.IDENT) && !p.peekTokenIs(token.STRING) {
+		p.errorf(CodeUnexpectedToken, p.peekToken.Pos, "expected module name after use, got %s instead", p.peekToken.Type)
+		return nil
+	}
+	p.nextToken()
+	use.FileName = p.curToken.Literal
+	if path.Ext(use.FileName) == "" {
+		use.FileName += ".mal"
+	}
+
+	switch {
+	// use strings as s, as不是关键字,仍然可以作为变量名
+	case p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "as":
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		use.Alias = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	// use strings { split, join }
+	case p.peekTokenIs(token.LBRACE):
+		p.nextToken()
+		use.Names = p.parseUseNames()
+		if use.Names == nil {
+			return nil
+		}
+	}
+	return use
+}
+
+// 解析选择导入的名字列表 { a, b }
+func (p *Parser) parseUseNames() []*ast.Identifier {
+	names := []*ast.Identifier{}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		names = append(names, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
 	p.nextToken()
-	f := p.curToken.Literal + ".mal"
-	return &ast.UseExpression{Token: use, FileName: f}
+
+	return names
 }
 
 // 解析函数-哈希表-前缀
@@ -396,9 +722,44 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	return hash
 }
 
+// 解析函数-宏-前缀
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	lit := &ast.MacroLiteral{Token: p.curToken}
+
+	// macro(
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	lit.Parameters = p.parseFunctionParameter()
+
+	// macro(){
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	lit.Body = p.parseBlockStatement()
+
+	return lit
+}
+
+// 解析函数-卫生宏-前缀
+func (p *Parser) parseHygienicMacroLiteral() ast.Expression {
+	// hygienic macro
+	if !p.expectPeek(token.MACRO) {
+		return nil
+	}
+	lit, ok := p.parseMacroLiteral().(*ast.MacroLiteral)
+	if !ok {
+		return nil
+	}
+	lit.Hygienic = true
+	return lit
+}
+
 // 解析函数-循环-前缀
 func (p *Parser) parseForExpression() ast.Expression {
-	expression := &ast.ForExpression{Token: p.curToken}
+	forToken := p.curToken
 
 	// for(
 	if !p.expectPeek(token.LPAREN) {
@@ -406,6 +767,13 @@ func (p *Parser) parseForExpression() ast.Expression {
 	}
 	p.nextToken()
 
+	// for(v range 或 for(i, v range
+	if p.curTokenIs(token.IDENT) && (p.peekTokenIs(token.RANGE) || p.peekTokenIs(token.COMMA)) {
+		return p.parseForRangeExpression(forToken)
+	}
+
+	expression := &ast.ForExpression{Token: forToken}
+
 	// 解析for()里的条件表达式
 	expression.Condition = p.parseExpression(LOWEST)
 
@@ -425,37 +793,82 @@ func (p *Parser) parseForExpression() ast.Expression {
 	return expression
 }
 
+// 解析范围循环,调用时curToken为第一个循环变量
+func (p *Parser) parseForRangeExpression(forToken token.Token) ast.Expression {
+	expression := &ast.ForRangeExpression{Token: forToken}
+
+	first := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expression.Key = first
+		expression.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	} else {
+		expression.Value = first
+	}
+
+	// for(i, v range
+	if !p.expectPeek(token.RANGE) {
+		return nil
+	}
+	p.nextToken()
+
+	expression.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Body = p.parseBlockStatement()
+
+	return expression
+}
+
 // 解析函数-break-前缀
 func (p *Parser) parseBreakStatement() ast.Expression {
-	return &ast.BreakExpression{}
+	return &ast.BreakExpression{Token: p.curToken}
 }
 
 // 解析函数-continue-前缀
 func (p *Parser) parseContinueStatement() ast.Expression {
-	return &ast.ContinueExpression{}
+	return &ast.ContinueExpression{Token: p.curToken}
 }
 
 // 创建解析器
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, diagnostics: []*Diagnostic{}}
 
 	// 关联解析函数
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
+	p.registerPrefix(token.NULL, p.parseNullLiteral)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
+	p.registerPrefix(token.HYGIENIC, p.parseHygienicMacroLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.TEMPLATE, p.parseTemplateLiteral)
+	p.registerPrefix(token.INVALID, p.parseInvalid)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
-	p.registerPrefix(token.COMMENT, p.parseCommentLiteral)
 	p.registerPrefix(token.USE, p.parseUseLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 	p.registerPrefix(token.FOR, p.parseForExpression)
+	// range在循环外作为内置函数名使用: range(0, 10)
+	p.registerPrefix(token.RANGE, p.parseIdentifier)
 	p.registerPrefix(token.BREAK, p.parseBreakStatement)
 	p.registerPrefix(token.CONTINUE, p.parseContinueStatement)
 
@@ -468,10 +881,25 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfix(token.SHL, p.parseInfixExpression)
+	p.registerInfix(token.SHR, p.parseInfixExpression)
 	p.registerInfix(token.AND, p.parseInfixExpression)
 	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseMemberExpression)
 
 	// 读取两个词法单元,设置peekToken和curToken
 	p.nextToken()
@@ -480,19 +908,94 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
+// 所有诊断信息
+func (p *Parser) Diagnostics() []*Diagnostic {
+	return p.diagnostics
+}
+
+// 格式化后的错误信息
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := []string{}
+	for _, d := range p.diagnostics {
+		if d.Severity == SeverityError {
+			errors = append(errors, d.String())
+		}
+	}
+	return errors
+}
+
+// 记录诊断信息,panic模式下忽略后续的连锁错误
+func (p *Parser) report(d *Diagnostic) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	p.diagnostics = append(p.diagnostics, d)
+}
+
+// 记录带位置信息的error
+func (p *Parser) errorf(code string, pos token.Position, format string, args ...interface{}) {
+	p.report(&Diagnostic{Pos: pos, Code: code, Message: fmt.Sprintf(format, args...)})
 }
 
 // 记录error
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.report(&Diagnostic{
+		Pos:      p.peekToken.Pos,
+		Code:     CodeUnexpectedToken,
+		Message:  fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type),
+		Expected: string(t),
+		Found:    string(p.peekToken.Type),
+	})
+}
+
+// 跳过出错语句剩下的词法单元,直到语句边界: ;、块的结尾}、换行后的let/return
+// depth为语句所在块的深度,返回true表示停在了该块的}上
+func (p *Parser) synchronize(depth int) bool {
+	p.panicking = false
+	for !p.curTokenIs(token.EOF) {
+		if p.depth < depth {
+			return true
+		}
+		if p.depth == depth {
+			if p.curTokenIs(token.SEMICOLON) || p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF) {
+				return false
+			}
+			if (p.peekTokenIs(token.LET) || p.peekTokenIs(token.RETURN)) &&
+				p.peekToken.Pos.Line > p.curToken.Pos.Line {
+				return false
+			}
+		}
+		p.nextToken()
+	}
+	return false
 }
 
 func (p *Parser) nextToken() {
-	p.curToken = p.peekToken
-	p.peekToken = p.l.NextToken()
+	p.curToken, p.curDoc = p.peekToken, p.peekDoc
+	p.peekToken, p.peekDoc = p.readToken()
+
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.depth++
+	case token.RBRACE:
+		p.depth--
+	}
+}
+
+// 读取下一个词法单元,跳过注释,并收集它前面的文档注释
+func (p *Parser) readToken() (token.Token, []string) {
+	var docs []string
+	for {
+		tok := p.l.NextToken()
+		switch tok.Type {
+		case token.COMMENT:
+		case token.DOC_COMMENT:
+			docs = append(docs, strings.TrimPrefix(tok.Literal, " "))
+		default:
+			return tok, docs
+		}
+	}
 }
 
 // 判断parser当前token是否和传入的token类型一致
@@ -520,14 +1023,14 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 
 // 解析let语句
 func (p *Parser) parseLetStatement() *ast.LetStatement {
-	stmt := &ast.LetStatement{Token: p.curToken}
+	stmt := &ast.LetStatement{Token: p.curToken, Doc: strings.Join(p.curDoc, "\n")}
 
 	// 如果接下来不是标识符(如果是,指针前移)
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
 
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	stmt.Name = p.parseBindingIdentifier()
 
 	// 如果接下来不是=
 	if !p.expectPeek(token.ASSIGN) {
@@ -545,6 +1048,23 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return stmt
 }
 
+// 解析export语句 export let x = 1;
+func (p *Parser) parseExportStatement() ast.Statement {
+	// 文档注释写在export前面
+	doc := p.curDoc
+	if !p.expectPeek(token.LET) {
+		return nil
+	}
+	p.curDoc = doc
+
+	stmt := p.parseLetStatement()
+	if stmt == nil {
+		return nil
+	}
+	stmt.Exported = true
+	return stmt
+}
+
 // 解析return语句
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
@@ -553,7 +1073,7 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 
-	for p.curTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -562,8 +1082,12 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 // 无法解析的语句
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.report(&Diagnostic{
+		Pos:     p.curToken.Pos,
+		Code:    CodeNoPrefix,
+		Message: fmt.Sprintf("no prefix parse function for %s found", t),
+		Found:   string(t),
+	})
 }
 
 // 解析表达式
@@ -614,6 +1138,9 @@ func (p *Parser) parseStatement() ast.Statement {
 	// 遇到LET开头就解析let语句
 	case token.LET:
 		return p.parseLetStatement()
+	// export let
+	case token.EXPORT:
+		return p.parseExportStatement()
 	// 遇到return开头就解析return语句
 	case token.RETURN:
 		return p.parseReturnStatement()
@@ -632,7 +1159,10 @@ func (p *Parser) ParseProgram() *ast.Program {
 	for !p.curTokenIs(token.EOF) {
 		// 解析语句
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.panicking {
+			// 出错的语句不加入AST,跳到下一条语句
+			p.synchronize(0)
+		} else if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
 		p.nextToken()
@@ -199,6 +199,15 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"5 < 5;", 5, "<", 5},
 		{"5 == 5;", 5, "==", 5},
 		{"5 != 5;", 5, "!=", 5},
+		{"5 <= 5;", 5, "<=", 5},
+		{"5 >= 5;", 5, ">=", 5},
+		{"5 % 5;", 5, "%", 5},
+		{"5 ** 5;", 5, "**", 5},
+		{"5 & 5;", 5, "&", 5},
+		{"5 | 5;", 5, "|", 5},
+		{"5 ^ 5;", 5, "^", 5},
+		{"5 << 5;", 5, "<<", 5},
+		{"5 >> 5;", 5, ">>", 5},
 		{"foobar + barfoo;", "foobar", "+", "barfoo"},
 		{"foobar - barfoo;", "foobar", "-", "barfoo"},
 		{"foobar * barfoo;", "foobar", "*", "barfoo"},
@@ -245,6 +254,42 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"-a * b",
 			"((-a) * b)",
 		},
+		{
+			"a + b % c",
+			"(a + (b % c))",
+		},
+		{
+			"-a ** b",
+			"(-(a ** b))",
+		},
+		{
+			"a ** b ** c",
+			"(a ** (b ** c))",
+		},
+		{
+			"a * b ** c",
+			"(a * (b ** c))",
+		},
+		{
+			"a ** -b",
+			"(a ** (-b))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a | b ^ c & d",
+			"(a | (b ^ (c & d)))",
+		},
+		{
+			"a & b << c + d",
+			"(a & (b << (c + d)))",
+		},
+		{
+			"a < b | c",
+			"(a < (b | c))",
+		},
 		{
 			"!-a",
 			"(!(-a))",
@@ -401,6 +446,21 @@ func TestBooleanExpression(t *testing.T) {
 	}
 }
 
+func TestNullLiteral(t *testing.T) {
+	l := lexer.New("null;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	if _, ok := stmt.Expression.(*ast.NullLiteral); !ok {
+		t.Fatalf("exp not *ast.NullLiteral. got=%T", stmt.Expression)
+	}
+	if stmt.Expression.String() != "null" {
+		t.Errorf("String() wrong. got=%q", stmt.Expression.String())
+	}
+}
+
 func TestIfExpression(t *testing.T) {
 	input := `if (x < y) { x }`
 
@@ -1044,4 +1104,580 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
-	
\ No newline at end of file
+	
+func TestParserErrorPosition(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x 5;", "test.mal:1:7: expected next token to be =, got INT instead"},
+		{"let x = 1;\nlet y = (2;", "test.mal:2:11: expected next token to be ), got ; instead"},
+		{"let x = 1;\n\t);", "test.mal:2:2: no prefix parse function for ) found"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewWithFile("test.mal", tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q", tt.input)
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestTemplateLiteralParsing(t *testing.T) {
+	input := `"total: ${sum(xs)} (${a + b * 2})"`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	tmpl, ok := stmt.Expression.(*ast.TemplateLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.TemplateLiteral. got=%T", stmt.Expression)
+	}
+
+	expected := []string{"total: ", "sum(xs)", " (", "(a + (b * 2))", ")"}
+	if len(tmpl.Parts) != len(expected) {
+		t.Fatalf("wrong number of parts. want=%d, got=%d", len(expected), len(tmpl.Parts))
+	}
+	for i, part := range tmpl.Parts {
+		if part.String() != expected[i] {
+			t.Errorf("parts[%d] wrong. want=%q, got=%q", i, expected[i], part.String())
+		}
+	}
+	if _, ok := tmpl.Parts[1].(*ast.CallExpression); !ok {
+		t.Errorf("parts[1] not *ast.CallExpression. got=%T", tmpl.Parts[1])
+	}
+}
+
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1;\nlet s = \"abc;", "2:9: unterminated string"},
+		{`"\q"`, `1:1: invalid escape sequence \q`},
+		{`"a ${}"`, "1:6: empty string interpolation"},
+		{`"a ${1 2}"`, "1:8: unexpected INT in string interpolation"},
+		{"\"a\n ${(1}\"", "2:6: expected next token to be ), got EOF instead"},
+		{"let x = @;", `1:9: illegal character "@"`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q", tt.input)
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{".5;", 0.5},
+		{"1e-9;", 1e-9},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
+func TestAssignExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5", "x = 5"},
+		{"x += 1 + 2", "x += (1 + 2)"},
+		{"x -= y * 2", "x -= (y * 2)"},
+		{"x *= 2", "x *= 2"},
+		{"x /= 2", "x /= 2"},
+		{"a = b = c", "a = b = c"},
+		{"a = b || c", "a = (b || c)"},
+		{"arr[0] = 1", "(arr[0]) = 1"},
+		{"h[\"a\"] += 2", "(h[a]) += 2"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if _, ok := stmt.Expression.(*ast.AssignExpression); !ok {
+			t.Fatalf("exp not *ast.AssignExpression. got=%T", stmt.Expression)
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestInvalidAssignTarget(t *testing.T) {
+	l := lexer.New("1 = 2")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 || errors[0] != "1:3: invalid assignment target: 1" {
+		t.Errorf("wrong errors. got=%q", errors)
+	}
+}
+
+func TestForRangeExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedKey   string
+		expectedValue string
+		expected      string
+	}{
+		{"for (v range arr) { v }", "", "v", "for(v range arr) v"},
+		{"for (i, v range arr) { i }", "i", "v", "for(i, v range arr) i"},
+		{"for (ch range range(0, 3)) { ch }", "", "ch", "for(ch range range(0, 3)) ch"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.ForRangeExpression)
+		if !ok {
+			t.Fatalf("exp not *ast.ForRangeExpression. got=%T", stmt.Expression)
+		}
+		if tt.expectedKey == "" && exp.Key != nil {
+			t.Errorf("exp.Key is not nil. got=%s", exp.Key)
+		}
+		if tt.expectedKey != "" && (exp.Key == nil || exp.Key.Value != tt.expectedKey) {
+			t.Errorf("exp.Key wrong. want=%s, got=%v", tt.expectedKey, exp.Key)
+		}
+		if exp.Value.Value != tt.expectedValue {
+			t.Errorf("exp.Value wrong. want=%s, got=%s", tt.expectedValue, exp.Value)
+		}
+		if exp.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, exp.String())
+		}
+	}
+}
+
+func TestElseIfExpression(t *testing.T) {
+	input := `if (x < 1) { a } else if (x < 2) { b } else { c }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.IfExpression. got=%T", stmt.Expression)
+	}
+	if len(exp.Alternative.Statements) != 1 {
+		t.Fatalf("exp.Alternative.Statements does not contain 1 statements. got=%d",
+			len(exp.Alternative.Statements))
+	}
+	alternative := exp.Alternative.Statements[0].(*ast.ExpressionStatement)
+	nested, ok := alternative.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("alternative is not ast.IfExpression. got=%T", alternative.Expression)
+	}
+	if !testInfixExpression(t, nested.Condition, "x", "<", 2) {
+		return
+	}
+	if nested.Alternative == nil || nested.Alternative.String() != "c" {
+		t.Errorf("nested.Alternative wrong. got=%v", nested.Alternative)
+	}
+	expected := "if(x < 1) aelse if(x < 2) belse c"
+	if program.String() != expected {
+		t.Errorf("expected=%q, got=%q", expected, program.String())
+	}
+}
+
+func TestMatchExpressionParsing(t *testing.T) {
+	input := `match (x) {
+		0 => "zero",
+		[a, _] if a > 1 => a,
+		{"k": v} => { v },
+		_ => null
+	}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MatchExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Subject, "x") {
+		return
+	}
+	if len(exp.Arms) != 4 {
+		t.Fatalf("exp.Arms does not contain 4 arms. got=%d", len(exp.Arms))
+	}
+	expected := []string{
+		"0 => zero",
+		"[a, _] if (a > 1) => a",
+		"{k:v} => v",
+		"_ => null",
+	}
+	for i, arm := range exp.Arms {
+		if arm.String() != expected[i] {
+			t.Errorf("arms[%d] wrong. expected=%q, got=%q", i, expected[i], arm.String())
+		}
+	}
+}
+
+func TestInvalidMatchPattern(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"match (x) { a + 1 => 1 }", "1:15: invalid pattern: (a + 1)"},
+		{"match (x) { [f(1)] => 1 }", "1:15: invalid pattern: f(1)"},
+		{"match (x) { 1 => 1 2 => 2 }", "1:20: expected next token to be ,, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("wrong errors. expected=%q, got=%q", tt.expected, errors)
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     []string
+		expectedStatements int
+	}{
+		{"let x 5; let y = 10;", []string{"1:7: expected next token to be =, got INT instead"}, 1},
+		{"let = 1\nlet y = 2", []string{"1:5: expected next token to be IDENT, got = instead"}, 1},
+		{"let f = fn(x) { x + }; let y = 2;", []string{"1:21: no prefix parse function for } found"}, 2},
+		{"let f = fn(x) { let = 1; x }; f(1)", []string{"1:21: expected next token to be IDENT, got = instead"}, 2},
+		{"if (x { 1 }; 2", []string{"1:7: expected next token to be ), got { instead"}, 1},
+		{"let a = {1: }; let b = 2;", []string{"1:13: no prefix parse function for } found"}, 1},
+		{"add(1, 2; let b = 3;", []string{"1:9: expected next token to be ), got ; instead"}, 1},
+		{
+			"let = 1; let y 2; 3 +;",
+			[]string{
+				"1:5: expected next token to be IDENT, got = instead",
+				"1:16: expected next token to be =, got INT instead",
+				"1:22: no prefix parse function for ; found",
+			},
+			0,
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("wrong number of errors for %q. want=%d, got=%q", tt.input, len(tt.expectedErrors), errors)
+			continue
+		}
+		for i, msg := range tt.expectedErrors {
+			if errors[i] != msg {
+				t.Errorf("errors[%d] wrong. want=%q, got=%q", i, msg, errors[i])
+			}
+		}
+		if len(program.Statements) != tt.expectedStatements {
+			t.Errorf("wrong number of statements for %q. want=%d, got=%d (%s)",
+				tt.input, tt.expectedStatements, len(program.Statements), program)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	l := lexer.NewWithFile("main.mal", "let x 5;\n)")
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 2 {
+		t.Fatalf("wrong number of diagnostics. want=2, got=%d", len(diagnostics))
+	}
+
+	d := diagnostics[0]
+	if d.Severity != SeverityError || d.Code != CodeUnexpectedToken {
+		t.Errorf("wrong severity or code. got=%s %s", d.Severity, d.Code)
+	}
+	if d.Expected != "=" || d.Found != "INT" {
+		t.Errorf("wrong expected/found. got=%q/%q", d.Expected, d.Found)
+	}
+	if d.String() != "main.mal:1:7: expected next token to be =, got INT instead" {
+		t.Errorf("wrong string. got=%q", d.String())
+	}
+
+	d = diagnostics[1]
+	if d.Code != CodeNoPrefix || d.Found != ")" || d.Pos.Line != 2 || d.Pos.Column != 1 {
+		t.Errorf("wrong diagnostic. got=%+v", d)
+	}
+}
+
+func TestCommentsAreSkipped(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1 // note", "let x = 1;"},
+		{"// only a comment", ""},
+		{"fn(x) { x // result\n}", "fn(x) x"},
+		{"add(1, // first\n 2 /* second */)", "add(1, 2)"},
+		{"let /* name */ y = [1, /* two */ 2];", "let y = [1, 2];"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestDocComments(t *testing.T) {
+	input := `
+/// 求和
+/// sum(arr)
+let sum = fn(arr) { 0 };
+
+// 普通注释
+let noDoc = 1;
+
+/// 不属于下一条语句
+1;
+let other = 2;
+`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := map[string]string{
+		"sum":   "求和\nsum(arr)",
+		"noDoc": "",
+		"other": "",
+	}
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok {
+			continue
+		}
+		if let.Doc != expected[let.Name.Value] {
+			t.Errorf("%s.Doc wrong. want=%q, got=%q", let.Name.Value, expected[let.Name.Value], let.Doc)
+		}
+	}
+}
+
+func TestMacroLiteralParsing(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MacroLiteral. got=%T",
+			stmt.Expression)
+	}
+
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("macro literal parameters wrong. want 2, got=%d\n",
+			len(macro.Parameters))
+	}
+
+	testLiteralExpression(t, macro.Parameters[0], "x")
+	testLiteralExpression(t, macro.Parameters[1], "y")
+
+	if len(macro.Body.Statements) != 1 {
+		t.Fatalf("macro.Body.Statements has not 1 statements. got=%d\n",
+			len(macro.Body.Statements))
+	}
+
+	bodyStmt, ok := macro.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("macro body stmt is not ast.ExpressionStatement. got=%T",
+			macro.Body.Statements[0])
+	}
+
+	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
+}
+
+func TestUnquoteBindingParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"hygienic macro(x) { x }", "hygienic macro(x) x"},
+		{"let unquote(name) = 1;", "let unquote(name) = 1;"},
+		{"fn(unquote(a), b) { b }", "fn(unquote(a), b) b"},
+		{"unquote(a) = 1", "unquote(a) = 1"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	l := lexer.New("f(a) = 1")
+	p := New(l)
+	p.ParseProgram()
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != "1:6: invalid assignment target: f(a)" {
+		t.Errorf("wrong errors. got=%q", errors)
+	}
+}
+
+func TestUseExpressionParsing(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{`use std`, "std.mal"},
+		{`use "lib/strings"`, "lib/strings.mal"},
+		{`use "lib/strings.mal"`, "lib/strings.mal"},
+	}
+	for _, tt := range ts {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		use, ok := stmt.Expression.(*ast.UseExpression)
+		if !ok {
+			t.Fatalf("exp not *ast.UseExpression. got=%T", stmt.Expression)
+		}
+		if use.FileName != tt.expected {
+			t.Errorf("use.FileName wrong. want=%q, got=%q", tt.expected, use.FileName)
+		}
+	}
+
+	p := New(lexer.New(`use 1`))
+	p.ParseProgram()
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != "1:5: expected module name after use, got INT instead" {
+		t.Errorf("wrong errors for `use 1`. got=%q", errors)
+	}
+}
+
+func TestModuleSyntaxParsing(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{`use strings as s`, "use strings.mal as s"},
+		{`use strings { split, join }`, "use strings.mal { split, join }"},
+		{`use strings {}`, "use strings.mal {  }"},
+		{`let as = 1; as`, "let as = 1;as"},
+		{`s.split(x)`, "s.split(x)"},
+		{`a.b.c[0]`, "(a.b.c[0])"},
+		{`-m.x * 2`, "((-m.x) * 2)"},
+		{`export let x = 1;`, "export let x = 1;"},
+	}
+	for _, tt := range ts {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %s. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`use strings as 1`, "1:16: expected next token to be IDENT, got INT instead"},
+		{`use strings { a b }`, "1:17: expected next token to be ,, got IDENT instead"},
+		{`s.(x)`, "1:3: expected next token to be IDENT, got ( instead"},
+		{`export x`, "1:8: expected next token to be LET, got IDENT instead"},
+	}
+	for _, tt := range errorTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("wrong errors for %s. want=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestExportDocComment(t *testing.T) {
+	p := New(lexer.New("/// 加一\nexport let inc = fn(x) { x + 1 };"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.LetStatement)
+	if !stmt.Exported || stmt.Doc != "加一" {
+		t.Errorf("wrong export statement. Exported=%t, Doc=%q", stmt.Exported, stmt.Doc)
+	}
+}
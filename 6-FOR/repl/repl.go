@@ -5,11 +5,13 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"malang/ast"
 	"malang/evaluator"
 	"malang/lexer"
 	"malang/object"
 	"malang/parser"
-	"malang/util"
+	"os"
+	"strings"
 )
 
 const PROMPT = ">> "
@@ -96,15 +98,14 @@ const ERROR_LOGO = `
 
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
+	// 宏和值共用一个环境,运行时的macroexpand才能找到宏
 	env := object.NewEnvironment()
-	macroEnv := object.NewEnvironment()
 	io.WriteString(out, MALRED_LOGO)
 	// 加载标准库
-	std := util.LoadStd()
-	l := lexer.New(std)
-	p := parser.New(l)
-	program := p.ParseProgram()
-	evaluator.Eval(program, env)
+	if err := evaluator.EvalStd(env); err != nil {
+		io.WriteString(out, err.Inspect())
+		io.WriteString(out, "\n")
+	}
 
 	for {
 		fmt.Fprintf(out, PROMPT)
@@ -114,17 +115,28 @@ func Start(in io.Reader, out io.Writer) {
 		}
 
 		line := scanner.Text()
+		// :expand 代码 / :expand1 代码, 查看宏展开的结果
+		if command, code, ok := parseExpandCommand(line); ok {
+			expandCommand(out, command, code, env)
+			continue
+		}
+
 		l := lexer.New(line)
 		p := parser.New(l)
 
 		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		if len(p.Diagnostics()) != 0 {
+			printParserErrors(out, p.Diagnostics())
 			continue
 		}
 
-		evaluator.DefineMacros(program, macroEnv)
-		expanded := evaluator.ExpandMacros(program, macroEnv)
+		evaluator.DefineMacros(program, env)
+		expanded, err := evaluator.ExpandMacros(program, env)
+		if err != nil {
+			io.WriteString(out, err.Inspect())
+			io.WriteString(out, "\n")
+			continue
+		}
 
 		// evaluated := evaluator.Eval(expanded, env)
 		evaluated := evaluator.Eval(expanded, env)
@@ -135,34 +147,79 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
+func parseExpandCommand(line string) (string, string, bool) {
+	for _, command := range []string{":expand1", ":expand"} {
+		if line == command || strings.HasPrefix(line, command+" ") {
+			return command, strings.TrimPrefix(line, command), true
+		}
+	}
+	return "", "", false
+}
+
+// 输出宏展开后的代码,不求值
+func expandCommand(out io.Writer, command, code string, env *object.Environment) {
+	p := parser.New(lexer.New(code))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		printParserErrors(out, p.Diagnostics())
+		return
+	}
+
+	for _, stmt := range program.Statements {
+		var expanded ast.Node
+		var err *object.Error
+		if command == ":expand1" {
+			expanded, err = evaluator.ExpandMacroOnce(stmt, env)
+		} else {
+			expanded, err = evaluator.ExpandMacros(stmt, env)
+		}
+		if err != nil {
+			io.WriteString(out, err.Inspect())
+			io.WriteString(out, "\n")
+			return
+		}
+		io.WriteString(out, expanded.String())
+		io.WriteString(out, "\n")
+	}
+}
+
+func printParserErrors(out io.Writer, diagnostics []*parser.Diagnostic) {
 	io.WriteString(out, MALRED_LOGO_IMG)
 	io.WriteString(out, ERROR_LOGO)
 	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	for _, d := range diagnostics {
+		// error[P001] main.mal:1:7: expected next token to be =, got INT instead
+		fmt.Fprintf(out, "\t%s[%s] %s\n", d.Severity, d.Code, d)
 	}
 }
 
-func ReadAndEval(input string) {
-	std := util.LoadStd()
-	l := lexer.New(std + input)
-	p := parser.New(l)
+func ReadAndEval(fileName, input string) {
 	env := object.NewEnvironment()
-	macroEnv := object.NewEnvironment()
+
+	// 标准库单独解析,保证报错的行号对应用户文件
+	if err := evaluator.EvalStd(env); err != nil {
+		fmt.Println(err.Inspect())
+		return
+	}
+
+	l := lexer.NewWithFile(fileName, input)
+	p := parser.New(l)
 	program := p.ParseProgram()
-	if len(p.Errors()) != 0 {
-		fmt.Println(MALRED_LOGO_IMG)
-		fmt.Println(ERROR_LOGO)
-		fmt.Println("Woops! We ran into some monkey business here!\n")
-		for _, msg := range p.Errors() {
-			fmt.Println("\t" + msg + "\n")
-		}
+	if len(p.Diagnostics()) != 0 {
+		printParserErrors(os.Stdout, p.Diagnostics())
+		return
 	}
 
-	evaluator.DefineMacros(program, macroEnv)
-	expanded := evaluator.ExpandMacros(program, macroEnv)
+	evaluator.DefineMacros(program, env)
+	expanded, err := evaluator.ExpandMacros(program, env)
+	if err != nil {
+		fmt.Println(err.Inspect())
+		return
+	}
 
-	evaluator.Eval(expanded, env)
+	evaluated := evaluator.Eval(expanded, env)
+	if evaluated != nil && evaluated.Type() == object.ERROR_OBJ {
+		fmt.Println(evaluated.Inspect())
+	}
 	// fmt.Printf(">> %v\n", evaluator.Eval(pro, env).Inspect())
 }
@@ -0,0 +1,191 @@
+// repl/vm.go
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"malang/ast"
+	"malang/compiler"
+	"malang/evaluator"
+	"malang/lexer"
+	"malang/object"
+	"malang/parser"
+	"malang/util"
+	"malang/vm"
+	"os"
+)
+
+// 使用虚拟机执行的控制台程序
+func StartVM(in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	// 宏在编译前展开,仍然需要一个环境保存宏定义
+	macroEnv := object.NewEnvironment()
+	// 符号表、常量池和全局变量在多行输入之间保留,前面的let在后面仍然可见
+	symbolTable := compiler.NewSymbolTableWithBuiltins()
+	constants := []object.Object{}
+	globals := make([]object.Object, vm.GlobalsSize)
+	io.WriteString(out, MALRED_LOGO)
+
+	// 编译并执行程序,出错时打印错误并返回nil
+	run := func(program *ast.Program) *vm.VM {
+		comp := compiler.NewWithState(symbolTable, constants)
+		_, err := compileProgram(comp, program, macroEnv)
+		// 编译出错时也保留常量池,符号表中记录的模块引用了其中的函数
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+		if err != nil {
+			io.WriteString(out, err.Error())
+			io.WriteString(out, "\n")
+			return nil
+		}
+
+		machine := vm.NewWithGlobals(bytecode, globals)
+		if err := machine.Run(); err != nil {
+			io.WriteString(out, err.Inspect())
+			io.WriteString(out, "\n")
+			return nil
+		}
+		return machine
+	}
+
+	// 加载标准库
+	if std := parseStd(out); std != nil {
+		run(std)
+	}
+
+	for {
+		fmt.Fprintf(out, PROMPT)
+		scanned := scanner.Scan()
+		if !scanned {
+			return
+		}
+
+		p := parser.New(lexer.New(scanner.Text()))
+		program := p.ParseProgram()
+		if len(p.Diagnostics()) != 0 {
+			printParserErrors(out, p.Diagnostics())
+			continue
+		}
+
+		machine := run(program)
+		if machine == nil {
+			continue
+		}
+		// 和解释器一样,以let等语句结束的输入没有值
+		n := len(program.Statements)
+		if n == 0 {
+			continue
+		}
+		if stmt, ok := program.Statements[n-1].(*ast.ExpressionStatement); ok && stmt.Expression != nil {
+			io.WriteString(out, machine.LastPoppedStackElem().Inspect())
+			io.WriteString(out, "\n")
+		}
+	}
+}
+
+// 编译文件并用虚拟机执行
+func ReadAndRunVM(fileName, input string) {
+	bytecode := compileFile(fileName, input)
+	if bytecode == nil {
+		return
+	}
+	runBytecode(bytecode)
+}
+
+// 编译文件并写入.malc文件,debug为true时保留报错用的源码位置
+func CompileFile(fileName, input, outName string, debug bool) {
+	bytecode := compileFile(fileName, input)
+	if bytecode == nil {
+		return
+	}
+	data, err := compiler.EncodeBytecode(bytecode, debug)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := ioutil.WriteFile(outName, data, 0644); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// 读取.malc文件并用虚拟机执行,不需要再解析源码和标准库
+func RunCompiled(fileName string) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	bytecode, err := compiler.DecodeBytecode(data)
+	if err != nil {
+		fmt.Printf("%s: %s\n", fileName, err)
+		return
+	}
+	runBytecode(bytecode)
+}
+
+// 把标准库和文件编译为一个程序,出错时打印错误并返回nil
+func compileFile(fileName, input string) *compiler.Bytecode {
+	comp := compiler.New()
+	macroEnv := object.NewEnvironment()
+
+	// 标准库单独解析,保证报错的行号对应用户文件
+	std := parseStd(os.Stdout)
+	if std == nil {
+		return nil
+	}
+	if _, err := compileProgram(comp, std, macroEnv); err != nil {
+		fmt.Println(err)
+		return nil
+	}
+
+	p := parser.New(lexer.NewWithFile(fileName, input))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		printParserErrors(os.Stdout, p.Diagnostics())
+		return nil
+	}
+
+	bytecode, err := compileProgram(comp, program, macroEnv)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	return bytecode
+}
+
+// 读取并解析标准库,出错时打印错误并返回nil
+func parseStd(out io.Writer) *ast.Program {
+	std, diagnostics, err := util.LoadStd()
+	if err != nil {
+		fmt.Fprintf(out, "cannot load std: %s\n", err)
+		return nil
+	}
+	if len(diagnostics) != 0 {
+		printParserErrors(out, diagnostics)
+		return nil
+	}
+	return std
+}
+
+func runBytecode(bytecode *compiler.Bytecode) {
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		fmt.Println(err.Inspect())
+	}
+}
+
+// 展开宏并用给定的编译器编译
+func compileProgram(comp *compiler.Compiler, program *ast.Program, macroEnv *object.Environment) (*compiler.Bytecode, error) {
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, err := evaluator.ExpandMacros(program, macroEnv)
+	if err != nil {
+		return nil, fmt.Errorf("%s", err.Inspect())
+	}
+
+	if err := comp.Compile(expanded); err != nil {
+		return nil, err
+	}
+	return comp.Bytecode(), nil
+}
@@ -0,0 +1,10 @@
+// std/std.go
+package std
+
+import "embed"
+
+// 标准库源文件,编译时嵌入到可执行文件中
+// 嵌入整个std目录(包括子目录),加载时只读取其中的.mal文件
+//
+//go:embed *
+var Files embed.FS
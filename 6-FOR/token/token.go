@@ -1,29 +1,50 @@
 // token/token.go
 package token
 
+import "fmt"
+
 const (
 	// 特殊类型
-	ILLEGAL = "ILLEGAL" // 未知字符
-	EOF     = "EOF"     // 文件结尾
-	COMMENT = "//"      // 注释
+	ILLEGAL     = "ILLEGAL" // 未知字符
+	INVALID     = "INVALID" // 词法错误(如未闭合的字符串),字面量为错误信息
+	EOF         = "EOF"     // 文件结尾
+	COMMENT     = "//"      // 注释,解析时会被跳过
+	DOC_COMMENT = "///"     // 文档注释,附加到后面的let语句
 
 	// 标识符+字面量
-	IDENT  = "IDENT"  // add, foobar, x, y
-	INT    = "INT"    // 1343456
-	STRING = "STRING" // "hello"
+	IDENT    = "IDENT"    // add, foobar, x, y
+	INT      = "INT"      // 1343456
+	FLOAT    = "FLOAT"    // 3.14 1e-9 .5
+	STRING   = "STRING"   // "hello"
+	TEMPLATE = "TEMPLATE" // "total: ${sum(xs)}",字面量为未处理转义的原始内容
 
 	// 运算符
-	ASSIGN   = "="
-	PLUS     = "+"
-	MINUS    = "-"
-	BANG     = "!"
-	ASTERISK = "*"
-	SLASH    = "/"
-	AND      = "&&"
-	OR       = "||"
-
-	LT = "<"
-	GT = ">"
+	ASSIGN          = "="
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+	PLUS            = "+"
+	MINUS           = "-"
+	BANG            = "!"
+	ASTERISK        = "*"
+	SLASH           = "/"
+	AND             = "&&"
+	OR              = "||"
+	PERCENT         = "%"
+	POWER           = "**"
+
+	// 位运算
+	BIT_AND = "&"
+	BIT_OR  = "|"
+	BIT_XOR = "^"
+	SHL     = "<<"
+	SHR     = ">>"
+
+	LT    = "<"
+	GT    = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
 
 	EQ     = "=="
 	NOT_EQ = "!="
@@ -32,6 +53,8 @@ const (
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
+	DOT       = "."
+	ARROW     = "=>"
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -45,12 +68,17 @@ const (
 	LET      = "LET"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
+	NULL     = "NULL"
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
 	USE      = "USE"
-	FOR      = "FOR"      // TODO
-	RANGE    = "RANGE"    // TODO
+	EXPORT   = "EXPORT"
+	MACRO    = "MACRO"
+	HYGIENIC = "HYGIENIC"
+	MATCH    = "MATCH"
+	FOR      = "FOR"
+	RANGE    = "RANGE"
 	BREAK    = "break"    // TODO
 	CONTINUE = "continue" // TODO
 )
@@ -61,10 +89,15 @@ var keywords = map[string]TokenType{
 	"let":      LET,
 	"true":     TRUE,
 	"false":    FALSE,
+	"null":     NULL,
 	"if":       IF,
 	"else":     ELSE,
 	"return":   RETURN,
 	"use":      USE,
+	"export":   EXPORT,
+	"macro":    MACRO,
+	"hygienic": HYGIENIC,
+	"match":    MATCH,
 	"for":      FOR,
 	"range":    RANGE,
 	"break":    BREAK,
@@ -83,9 +116,36 @@ func LookupIdent(ident string) TokenType {
 // 词法单元类型
 type TokenType string
 
+// 源码位置
+type Position struct {
+	File   string // 文件名(可能为空,如repl输入)
+	Line   int    // 行号,从1开始
+	Column int    // 列号,从1开始
+}
+
+// 行号为0表示没有位置信息
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// 格式: file:line:column 或 line:column
+func (p Position) String() string {
+	s := p.File
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
 // 词法单元
 type Token struct {
 	Type TokenType
 	// 字面量
 	Literal string
+	// 词法单元在源码中的起始位置
+	Pos Position
 }
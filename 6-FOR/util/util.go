@@ -1,29 +1,108 @@
 package util
 
 import (
+	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"malang/ast"
 	"malang/lexer"
 	"malang/parser"
+	"malang/std"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
-// 加载标准库
-func LoadStd() string {
-	// todo: 改为循环读取std目录
-	buf, err := ioutil.ReadFile("./std/std.mal")
+// 模块搜索路径的环境变量,多个目录用系统路径分隔符(: 或 ;)分隔
+const MALANG_PATH = "MALANG_PATH"
+
+// 模块文件扩展名
+const MAL_EXT = ".mal"
+
+// 编译后的字节码文件扩展名
+const MALC_EXT = ".malc"
+
+// 标准库目录的环境变量,设置后从该目录读取标准库(开发时使用),否则使用嵌入的std
+const MALANG_STD = "MALANG_STD"
+
+// 加载标准库目录(包括子目录)中的所有.mal文件,按路径顺序合并为一个程序
+func LoadStd() (*ast.Program, []*parser.Diagnostic, error) {
+	files, dir := fs.FS(std.Files), "std"
+	if override := os.Getenv(MALANG_STD); override != "" {
+		files, dir = os.DirFS(override), override
+	}
+
+	names := []string{}
+	err := fs.WalkDir(files, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && filepath.Ext(path) == MAL_EXT {
+			names = append(names, path)
+		}
+		return nil
+	})
 	if err != nil {
-		panic(err)
+		return nil, nil, err
+	}
+	sort.Strings(names)
+
+	program := &ast.Program{Statements: []ast.Statement{}}
+	diagnostics := []*parser.Diagnostic{}
+	for _, name := range names {
+		buf, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		// 每个文件单独解析,报错位置对应标准库中的文件
+		p := parser.New(lexer.NewWithFile(filepath.Join(dir, name), string(buf)))
+		program.Statements = append(program.Statements, p.ParseProgram().Statements...)
+		diagnostics = append(diagnostics, p.Diagnostics()...)
 	}
-	return string(buf)
+	return program, diagnostics, nil
 }
 
-// 加载用户定义的文件(返回加载后的字符串)
-func LoadMalFile(filePath string) *ast.Program {
+// 加载用户定义的文件,返回解析后的程序和语法错误
+func LoadMalFile(filePath string) (*ast.Program, []*parser.Diagnostic, error) {
 	buf, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
-	l := lexer.New(string(buf))
+	l := lexer.NewWithFile(filePath, string(buf))
 	p := parser.New(l)
-	return p.ParseProgram()
+	return p.ParseProgram(), p.Diagnostics(), nil
+}
+
+// 查找use导入的模块文件
+// 依次在导入者所在目录(importer为空时是当前目录)和MALANG_PATH的各个目录中查找
+func ResolveModule(name, importer string) (string, error) {
+	if filepath.Ext(name) == "" {
+		name += MAL_EXT
+	}
+	if filepath.IsAbs(name) {
+		if isFile(name) {
+			return filepath.Clean(name), nil
+		}
+		return "", fmt.Errorf("module not found: %s", name)
+	}
+
+	dirs := []string{filepath.Dir(importer)}
+	for _, dir := range filepath.SplitList(os.Getenv(MALANG_PATH)) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if isFile(path) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("module not found: %s (searched %s)", name, strings.Join(dirs, ", "))
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
 }
@@ -0,0 +1,63 @@
+// vm/iterator.go
+package vm
+
+import "malang/object"
+
+// 范围循环的迭代器,只在循环期间留在栈上
+// 和解释器一样: 数组和字符串得到(下标, 元素); 哈希表按键排序得到(键, 值),只有一个循环变量时为键; 整数n得到0到n-1
+type iterator struct {
+	next func() (key, value object.Object, ok bool)
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "iterator" }
+
+func newIterator(iterable object.Object, numVars int) (*iterator, *object.Error) {
+	i := 0
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		// 长度在循环开始时确定,元素在每次迭代时读取
+		elements := iterable.Elements
+		return &iterator{next: func() (object.Object, object.Object, bool) {
+			if i >= len(elements) {
+				return nil, nil, false
+			}
+			i++
+			return &object.Integer{Value: int64(i - 1)}, elements[i-1], true
+		}}, nil
+	case *object.Hash:
+		pairs := iterable.SortedPairs()
+		return &iterator{next: func() (object.Object, object.Object, bool) {
+			if i >= len(pairs) {
+				return nil, nil, false
+			}
+			pair := pairs[i]
+			i++
+			if numVars == 1 {
+				return pair.Key, pair.Key, true
+			}
+			return pair.Key, pair.Value, true
+		}}, nil
+	case *object.String:
+		runes := []rune(iterable.Value)
+		return &iterator{next: func() (object.Object, object.Object, bool) {
+			if i >= len(runes) {
+				return nil, nil, false
+			}
+			i++
+			return &object.Integer{Value: int64(i - 1)}, &object.String{Value: string(runes[i-1])}, true
+		}}, nil
+	case *object.Integer:
+		n := iterable.Value
+		return &iterator{next: func() (object.Object, object.Object, bool) {
+			if int64(i) >= n {
+				return nil, nil, false
+			}
+			i++
+			value := &object.Integer{Value: int64(i - 1)}
+			return value, value, true
+		}}, nil
+	default:
+		return nil, newError("cannot range over %s", iterable.Type())
+	}
+}
@@ -0,0 +1,674 @@
+// vm/vm.go
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"malang/code"
+	"malang/compiler"
+	"malang/evaluator"
+	"malang/object"
+)
+
+const StackSize = 2048
+const GlobalsSize = 65536
+const MaxFrames = 1024
+
+// 二元运算指令对应的运算符,运算本身复用evaluator中的实现,保证和解释器的语义一致
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpPow:          "**",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShl:          "<<",
+	code.OpShr:          ">>",
+	code.OpGreaterThan:  ">",
+	code.OpGreaterEqual: ">=",
+	code.OpLessThan:     "<",
+	code.OpLessEqual:    "<=",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+}
+
+// 基于栈的虚拟机
+type VM struct {
+	constants []object.Object
+
+	stack []object.Object
+	sp    int // 始终指向栈中下一个空闲位置,栈顶为stack[sp-1]
+
+	globals     []object.Object // 全局变量存储
+	globalNames []string        // 全局变量的名字,没有调试信息时为空
+
+	frames      []*Frame
+	framesIndex int
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	// 主程序也放在一个调用帧中执行
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Lines: bytecode.Lines}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	return &VM{
+		constants: bytecode.Constants,
+
+		stack: make([]object.Object, StackSize),
+		sp:    0,
+
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.GlobalNames,
+
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// 使用已有的全局变量存储创建虚拟机,控制台用它在多行输入之间保留全局变量
+func NewWithGlobals(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) *object.Error {
+	if vm.framesIndex >= MaxFrames {
+		return newError("stack overflow")
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// 栈顶元素
+func (vm *VM) StackTop() object.Object {
+	if vm.sp == 0 {
+		return nil
+	}
+	return vm.stack[vm.sp-1]
+}
+
+// 最后一个被弹出的元素,即最后一条表达式语句的值
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.stack[vm.sp]
+}
+
+// 执行字节码,运行时错误和解释器一样用*object.Error表示
+func (vm *VM) Run() *object.Error {
+	err := vm.run()
+	// 有调试信息时,用出错指令的源码位置补充错误
+	if err != nil && !err.Pos.IsValid() {
+		frame := vm.currentFrame()
+		err.Pos = frame.cl.Fn.Lines.Lookup(frame.ip)
+	}
+	return err
+}
+
+func (vm *VM) run() *object.Error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(vm.constants[constIndex]); err != nil {
+				return err
+			}
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShl, code.OpShr,
+			code.OpGreaterThan, code.OpGreaterEqual, code.OpLessThan, code.OpLessEqual,
+			code.OpEqual, code.OpNotEqual:
+			right := vm.pop()
+			left := vm.pop()
+			result := evaluator.EvalInfixExpression(infixOperators[op], left, right)
+			if err := vm.pushResult(result); err != nil {
+				return err
+			}
+		case code.OpBang:
+			if err := vm.pushResult(evaluator.EvalPrefixExpression("!", vm.pop())); err != nil {
+				return err
+			}
+		case code.OpMinus:
+			if err := vm.pushResult(evaluator.EvalPrefixExpression("-", vm.pop())); err != nil {
+				return err
+			}
+		case code.OpTrue:
+			if err := vm.push(evaluator.TRUE); err != nil {
+				return err
+			}
+		case code.OpFalse:
+			if err := vm.push(evaluator.FALSE); err != nil {
+				return err
+			}
+		case code.OpNull:
+			if err := vm.push(evaluator.NULL); err != nil {
+				return err
+			}
+		case code.OpPop:
+			vm.pop()
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			// 下一轮循环开始时ip会加1
+			vm.currentFrame().ip = pos - 1
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if !evaluator.IsTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.globals[globalIndex] = vm.pop()
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			// 没有执行的let(例如控制台中前面出错的行)定义了符号却没有赋值
+			global := vm.globals[globalIndex]
+			if global == nil {
+				return vm.uninitializedGlobal(int(globalIndex))
+			}
+			if err := vm.push(global); err != nil {
+				return err
+			}
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			elements := make([]object.Object, numElements)
+			copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(&object.Array{Elements: elements}); err != nil {
+				return err
+			}
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(hash); err != nil {
+				return err
+			}
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			if err := vm.pushResult(evaluator.EvalIndexExpression(left, index)); err != nil {
+				return err
+			}
+		case code.OpTemplate:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			var out bytes.Buffer
+			for _, part := range vm.stack[vm.sp-numParts : vm.sp] {
+				out.WriteString(evaluator.TemplatePart(part))
+			}
+			vm.sp = vm.sp - numParts
+
+			if err := vm.push(&object.String{Value: out.String()}); err != nil {
+				return err
+			}
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			// 和全局绑定一样,没有执行的let(例如if的另一个分支)定义了符号却没有赋值
+			local := vm.stack[vm.currentFrame().basePointer+int(localIndex)]
+			if local == nil {
+				return uninitialized(vm.currentFrame().cl.Fn.LocalNames, int(localIndex))
+			}
+			if err := vm.push(local); err != nil {
+				return err
+			}
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			builtin, _ := evaluator.LookupBuiltin(evaluator.BuiltinNames[builtinIndex])
+			if err := vm.push(builtin); err != nil {
+				return err
+			}
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+				return err
+			}
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			free := vm.currentFrame().cl.Free[freeIndex]
+			if free == nil {
+				return uninitialized(vm.currentFrame().cl.Fn.FreeNames, int(freeIndex))
+			}
+			if err := vm.push(free); err != nil {
+				return err
+			}
+		case code.OpCurrentClosure:
+			if err := vm.push(vm.currentFrame().cl); err != nil {
+				return err
+			}
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeCall(int(numArgs)); err != nil {
+				return err
+			}
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			// 主程序中的return结束整个程序,返回值作为最后弹出的值
+			if vm.framesIndex == 1 {
+				return nil
+			}
+
+			frame := vm.popFrame()
+			// 同时弹出局部绑定和被调用的函数
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(returnValue); err != nil {
+				return err
+			}
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(evaluator.NULL); err != nil {
+				return err
+			}
+		case code.OpSetIndex:
+			operator := "="
+			if assignOp := code.Opcode(code.ReadUint8(ins[ip+1:])); assignOp != 0 {
+				operator = infixOperators[assignOp] + "="
+			}
+			vm.currentFrame().ip += 1
+
+			val := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			if err := vm.pushResult(evaluator.AssignIndex(operator, left, index, val)); err != nil {
+				return err
+			}
+		case code.OpIter:
+			numVars := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			iter, err := newIterator(vm.pop(), int(numVars))
+			if err != nil {
+				return err
+			}
+			if err := vm.push(iter); err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			// 指令流经过检查,但栈上的值的类型只能在运行时检查
+			iter, ok := vm.StackTop().(*iterator)
+			if !ok {
+				return newError("not an iterator: %s", vm.StackTop().Type())
+			}
+			key, value, ok := iter.next()
+			if !ok {
+				vm.currentFrame().ip = pos - 1
+				continue
+			}
+			if err := vm.push(key); err != nil {
+				return err
+			}
+			if err := vm.push(value); err != nil {
+				return err
+			}
+		case code.OpGetLocalCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			c, ok := vm.stack[vm.currentFrame().basePointer+int(localIndex)].(*cell)
+			if !ok {
+				return uninitialized(vm.currentFrame().cl.Fn.LocalNames, int(localIndex))
+			}
+			if err := vm.push(c.value); err != nil {
+				return err
+			}
+		case code.OpSetLocalCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			slot := vm.currentFrame().basePointer + int(localIndex)
+			if c, ok := vm.stack[slot].(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				vm.stack[slot] = &cell{value: vm.pop()}
+			}
+		case code.OpGetFreeCell:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			c, ok := vm.currentFrame().cl.Free[freeIndex].(*cell)
+			if !ok {
+				return uninitialized(vm.currentFrame().cl.Fn.FreeNames, int(freeIndex))
+			}
+			if err := vm.push(c.value); err != nil {
+				return err
+			}
+		case code.OpSetFreeCell:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			c, ok := vm.currentFrame().cl.Free[freeIndex].(*cell)
+			if !ok {
+				return uninitialized(vm.currentFrame().cl.Fn.FreeNames, int(freeIndex))
+			}
+			c.value = vm.pop()
+		case code.OpClearLocals:
+			start := int(code.ReadUint8(ins[ip+1:]))
+			count := int(code.ReadUint8(ins[ip+2:]))
+			vm.currentFrame().ip += 2
+
+			vm.clearLocals(vm.currentFrame().basePointer+start, count)
+		case code.OpGetGlobalCell:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			c, ok := vm.globals[globalIndex].(*cell)
+			if !ok {
+				return vm.uninitializedGlobal(int(globalIndex))
+			}
+			if err := vm.push(c.value); err != nil {
+				return err
+			}
+		case code.OpSetGlobalCell:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if c, ok := vm.globals[globalIndex].(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				vm.globals[globalIndex] = &cell{value: vm.pop()}
+			}
+		case code.OpClearGlobals:
+			start := int(code.ReadUint16(ins[ip+1:]))
+			count := int(code.ReadUint16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			// 只清空cell,块中的模块和没有被捕获的变量不受影响
+			for i := start; i < start+count && i < len(vm.globals); i++ {
+				if _, ok := vm.globals[i].(*cell); ok {
+					vm.globals[i] = nil
+				}
+			}
+		case code.OpMatchArray:
+			length := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			arr, ok := vm.pop().(*object.Array)
+			if err := vm.push(nativeBoolToBooleanObject(ok && len(arr.Elements) == length)); err != nil {
+				return err
+			}
+		case code.OpMatchHash:
+			_, ok := vm.pop().(*object.Hash)
+			if err := vm.push(nativeBoolToBooleanObject(ok)); err != nil {
+				return err
+			}
+		case code.OpMatchKey:
+			key := vm.pop()
+			hash, ok := vm.pop().(*object.Hash)
+			hashKey, isHashable := key.(object.Hashable)
+			if !isHashable {
+				return newError("unusable as hash key: %s", key.Type())
+			}
+			if ok {
+				_, ok = hash.Pairs[hashKey.HashKey()]
+			}
+			if err := vm.push(nativeBoolToBooleanObject(ok)); err != nil {
+				return err
+			}
+		case code.OpMatchLiteral:
+			value := vm.pop()
+			expected := vm.pop()
+			if err := vm.push(nativeBoolToBooleanObject(evaluator.LiteralEqual(expected, value))); err != nil {
+				return err
+			}
+		case code.OpGetModule:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			pos := int(code.ReadUint16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			// 模块已经运行过时跳过运行模块的指令
+			if module := vm.globals[globalIndex]; module != nil {
+				if err := vm.push(module); err != nil {
+					return err
+				}
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpModule:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			numElements := int(code.ReadUint16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			module := &object.Module{
+				Name: vm.constants[nameIndex].(*object.String).Value,
+				Env:  object.NewEnvironment(),
+			}
+			for i := vm.sp - numElements; i < vm.sp; i += 2 {
+				name, ok := vm.stack[i].(*object.String)
+				if !ok {
+					return newError("module member name must be STRING. got %s", vm.stack[i].Type())
+				}
+				module.Env.Set(name.Value, vm.stack[i+1])
+			}
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(module); err != nil {
+				return err
+			}
+		case code.OpMember:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			name := vm.constants[nameIndex].(*object.String).Value
+			if err := vm.pushResult(evaluator.GetMember(vm.pop(), name)); err != nil {
+				return err
+			}
+		case code.OpBreakValue:
+			if err := vm.push(evaluator.BREAK); err != nil {
+				return err
+			}
+		case code.OpContinueValue:
+			if err := vm.push(evaluator.CONTINUE); err != nil {
+				return err
+			}
+		default:
+			return newError("unknown opcode %d", op)
+		}
+	}
+
+	return nil
+}
+
+// 调用栈中参数下方的函数
+func (vm *VM) executeCall(numArgs int) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return newError("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) *object.Error {
+	if numArgs != cl.Fn.NumParameters {
+		return newError("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
+	// 参数已经在栈上,成为前几个局部绑定
+	frame := NewFrame(cl, vm.sp-numArgs)
+	if frame.basePointer+cl.Fn.NumLocals >= StackSize {
+		return newError("stack overflow")
+	}
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
+	// 为其余的局部绑定预留空间,清空上一次调用留下的值
+	vm.clearLocals(vm.sp, cl.Fn.NumLocals-numArgs)
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	return nil
+}
+
+func (vm *VM) clearLocals(start, count int) {
+	for i := start; i < start+count; i++ {
+		vm.stack[i] = nil
+	}
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) *object.Error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Fn(args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	return vm.pushResult(result)
+}
+
+// 用常量池中的函数和栈上的自由变量构建闭包
+func (vm *VM) pushClosure(constIndex int, numFree int) *object.Error {
+	function, ok := vm.constants[constIndex].(*object.CompiledFunction)
+	if !ok {
+		return newError("not a function: %s", vm.constants[constIndex].Type())
+	}
+
+	free := make([]object.Object, numFree)
+	copy(free, vm.stack[vm.sp-numFree:vm.sp])
+	vm.sp = vm.sp - numFree
+
+	return vm.push(&object.Closure{Fn: function, Free: free})
+}
+
+// 用栈中[startIndex, endIndex)的键值对构建哈希表
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, *object.Error) {
+	pairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, newError("unusable as hash key: %s", key.Type())
+		}
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: pairs}, nil
+}
+
+func (vm *VM) push(o object.Object) *object.Error {
+	if vm.sp >= StackSize {
+		return newError("stack overflow")
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+// 压入运算结果,结果是错误时中止执行
+func (vm *VM) pushResult(result object.Object) *object.Error {
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+	return vm.push(result)
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return evaluator.TRUE
+	}
+	return evaluator.FALSE
+}
+
+// 被闭包捕获的局部变量,外层函数和闭包通过同一个cell读写变量
+type cell struct {
+	value object.Object
+}
+
+func (c *cell) Type() object.ObjectType { return "CELL" }
+func (c *cell) Inspect() string         { return "cell" }
+
+// 读取没有赋值的全局变量,调试信息中有名字时报告名字
+func (vm *VM) uninitializedGlobal(index int) *object.Error {
+	if index < len(vm.globalNames) {
+		return newError("uninitialized global variable: %s", vm.globalNames[index])
+	}
+	return newError("uninitialized global variable")
+}
+
+// 读写没有赋值的局部变量,调试信息中有名字时报告名字
+func uninitialized(names []string, index int) *object.Error {
+	if index < len(names) {
+		return newError("uninitialized local variable: %s", names[index])
+	}
+	return newError("uninitialized local variable")
+}
+
+func newError(format string, args ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, args...)}
+}
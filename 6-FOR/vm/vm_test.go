@@ -0,0 +1,1058 @@
+package vm
+
+import (
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"malang/ast"
+	"malang/code"
+	"malang/compiler"
+	"malang/evaluator"
+	"malang/lexer"
+	"malang/object"
+	"malang/parser"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+// 期望得到运行时错误
+type vmError struct {
+	message string
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+// 同时用虚拟机和解释器执行,两者的结果都要符合期望
+func runVmTests(t *testing.T, ts []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range ts {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		var result object.Object
+		if err := vm.Run(); err != nil {
+			result = err
+		} else {
+			result = vm.LastPoppedStackElem()
+		}
+		testExpectedObject(t, tt.input, "vm", tt.expected, result)
+
+		evaluated := evaluator.Eval(parse(tt.input), object.NewEnvironment())
+		testExpectedObject(t, tt.input, "evaluator", tt.expected, evaluated)
+	}
+}
+
+func testExpectedObject(t *testing.T, input, backend string, expected interface{}, actual object.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		if err := testIntegerObject(int64(expected), actual); err != "" {
+			t.Errorf("%s: %q: %s", backend, input, err)
+		}
+	case float64:
+		res, ok := actual.(*object.Float)
+		if !ok || res.Value != expected {
+			t.Errorf("%s: %q: want float %v, got=%T (%+v)", backend, input, expected, actual, actual)
+		}
+	case bool:
+		res, ok := actual.(*object.Boolean)
+		if !ok || res.Value != expected {
+			t.Errorf("%s: %q: want boolean %t, got=%T (%+v)", backend, input, expected, actual, actual)
+		}
+	case string:
+		res, ok := actual.(*object.String)
+		if !ok || res.Value != expected {
+			t.Errorf("%s: %q: want string %q, got=%T (%+v)", backend, input, expected, actual, actual)
+		}
+	case nil:
+		if actual != evaluator.NULL {
+			t.Errorf("%s: %q: want null, got=%T (%+v)", backend, input, actual, actual)
+		}
+	case []int:
+		arr, ok := actual.(*object.Array)
+		if !ok || len(arr.Elements) != len(expected) {
+			t.Errorf("%s: %q: want array %v, got=%T (%+v)", backend, input, expected, actual, actual)
+			return
+		}
+		for i, el := range expected {
+			if err := testIntegerObject(int64(el), arr.Elements[i]); err != "" {
+				t.Errorf("%s: %q: element %d: %s", backend, input, i, err)
+			}
+		}
+	case map[object.HashKey]int64:
+		hash, ok := actual.(*object.Hash)
+		if !ok || len(hash.Pairs) != len(expected) {
+			t.Errorf("%s: %q: want hash with %d pairs, got=%T (%+v)", backend, input, len(expected), actual, actual)
+			return
+		}
+		for key, val := range expected {
+			pair, ok := hash.Pairs[key]
+			if !ok {
+				t.Errorf("%s: %q: no pair for given key", backend, input)
+				continue
+			}
+			if err := testIntegerObject(val, pair.Value); err != "" {
+				t.Errorf("%s: %q: %s", backend, input, err)
+			}
+		}
+	case vmError:
+		errObj, ok := actual.(*object.Error)
+		if !ok {
+			t.Errorf("%s: %q: no error returned. got=%T (%+v)", backend, input, actual, actual)
+			return
+		}
+		if errObj.Message != expected.message {
+			t.Errorf("%s: %q: wrong error message. want=%q, got=%q", backend, input, expected.message, errObj.Message)
+		}
+	default:
+		t.Fatalf("unsupported expected type %T", expected)
+	}
+}
+
+func testIntegerObject(expected int64, actual object.Object) string {
+	res, ok := actual.(*object.Integer)
+	if !ok {
+		return "object is not Integer"
+	}
+	if res.Value != expected {
+		return "object has wrong value"
+	}
+	return ""
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	ts := []vmTestCase{
+		{"5", 5},
+		{"-5", -5},
+		{"5 + 5 + 5 + 5 - 10", 10},
+		{"2 * 2 * 2 * 2 * 2", 32},
+		{"-50 + 100 + -50", 0},
+		{"5 + 2 * 10", 25},
+		{"20 + 2 * -10", 0},
+		{"50 / 2 * 2 + 10", 60},
+		{"2 * (5 + 10)", 30},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"3 * 2 ** 2", 12},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"1 << 4", 16},
+		{"256 >> 2", 64},
+		{"1 | 2 ^ 6 & 3", 1},
+	}
+	runVmTests(t, ts)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	ts := []vmTestCase{
+		{"3.14", 3.14},
+		{".5", 0.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"7 / 2.0", 3.5},
+		{"2 * 1.25 - 1", 1.5},
+		{"7.5 % 2", 1.5},
+		{"2 ** -1", 0.5},
+		{"9.0 ** 0.5", 3.0},
+	}
+	runVmTests(t, ts)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	ts := []vmTestCase{
+		{"true", true},
+		{"false", false},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 < 1", false},
+		{"1 == 1", true},
+		{"1 != 2", true},
+		{"(1 < 2) == true", true},
+		{"(1 > 2) == false", true},
+		{"1.5 < 2", true},
+		{"1 == 1.0", true},
+		{"0.1 + 0.2 == 0.3", false},
+		{"1 <= 2", true},
+		{"3 <= 2", false},
+		{"2 >= 2", true},
+		{"2 >= 2.5", false},
+		{`"a" < "b"`, true},
+		{`"b" > "abc"`, true},
+		{`"ab" >= "abc"`, false},
+		{`"abc" == "abc"`, true},
+		{`"a" + "bc" == "abc"`, true},
+		{"!true", false},
+		{"!5", false},
+		{"!!5", true},
+		{"!(if (false) { 5; })", true},
+	}
+	runVmTests(t, ts)
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	ts := []vmTestCase{
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 && 2", true},
+		{`"" && [1]`, true},
+		{"if (false) { 1 } && true", false},
+		{"let x = if (false) { 1 }; x || 5", true},
+		{"false && 1 + true", false},
+		{"true || 1 / 0", true},
+		{"true && false || true", true},
+	}
+	runVmTests(t, ts)
+}
+
+func TestConditionals(t *testing.T) {
+	ts := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (false) { 10 }", nil},
+		{"if (1) { 10 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 1) { 20 } else { 30 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 }", nil},
+		{"let x = 3; if (x == 1) { 10 } else if (x == 2) { 20 } else if (x == 3) { 30 } else { 40 }", 30},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+	}
+	runVmTests(t, ts)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	ts := []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = 2; one + two", 3},
+		{"let one = 1; let two = one + one; one + two", 3},
+		{"let a = 1; let a = a + 1; a", 2},
+	}
+	runVmTests(t, ts)
+}
+
+func TestStringExpressions(t *testing.T) {
+	ts := []vmTestCase{
+		{`"hello world"`, "hello world"},
+		{`"hello" + " " + "world"`, "hello world"},
+		{`"tab\there\n"`, "tab\there\n"},
+		{"`raw ${x} \\n`", `raw ${x} \n`},
+		{`let x = 3; "x = ${x}"`, "x = 3"},
+		{`let xs = [1, 2, 3]; "total: ${xs[0] + xs[1] + xs[2]}!"`, "total: 6!"},
+		{`"${"a" + "b"}${[1, 2]}${true}"`, "ab[1, 2]true"},
+	}
+	runVmTests(t, ts)
+}
+
+func TestArrayLiterals(t *testing.T) {
+	ts := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2, 3]", []int{1, 2, 3}},
+		{"[1, 2 * 2, 3 + 3]", []int{1, 4, 6}},
+	}
+	runVmTests(t, ts)
+}
+
+func TestHashLiterals(t *testing.T) {
+	ts := []vmTestCase{
+		{"{}", map[object.HashKey]int64{}},
+		{
+			`let two = "two"; {"one": 10 - 9, two: 1 + 1, "thr" + "ee": 6 / 2, 4: 4, true: 5, false: 6}`,
+			map[object.HashKey]int64{
+				(&object.String{Value: "one"}).HashKey():   1,
+				(&object.String{Value: "two"}).HashKey():   2,
+				(&object.String{Value: "three"}).HashKey(): 3,
+				(&object.Integer{Value: 4}).HashKey():      4,
+				evaluator.TRUE.HashKey():                   5,
+				evaluator.FALSE.HashKey():                  6,
+			},
+		},
+	}
+	runVmTests(t, ts)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	ts := []vmTestCase{
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][1 + 1]", 3},
+		{"let myArr = [1, 2, 3]; let i = myArr[0]; myArr[i];", 2},
+		{"[1, 2, 3][3]", nil},
+		{"[1, 2, 3][-1]", nil},
+		{`{"foo": 5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, nil},
+		{`let key = "foo"; {"foo": 5}[key]`, 5},
+		{"{}[0]", nil},
+		{"{true: 5}[true]", 5},
+		{"{2.5: 5}[5 / 2.0]", 5},
+		{"{1: 5}[1.0]", nil},
+	}
+	runVmTests(t, ts)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	ts := []vmTestCase{
+		{"5 + true;", vmError{"type mismatch: INTEGER + BOOLEAN"}},
+		{"5 + true; 5;", vmError{"type mismatch: INTEGER + BOOLEAN"}},
+		{"-true", vmError{"unknown operator: -BOOLEAN"}},
+		{"5; true + false; 5;", vmError{"unknown operator: BOOLEAN + BOOLEAN"}},
+		{"if (10 > 1) { true + false; }", vmError{"unknown operator: BOOLEAN + BOOLEAN"}},
+		{`"hello" - "world"`, vmError{"unknown operator: STRING - STRING"}},
+		{"1.5 + true", vmError{"type mismatch: FLOAT + BOOLEAN"}},
+		{"1 / 0", vmError{"division by zero: 1 / 0"}},
+		{"5 % 0", vmError{"division by zero: 5 % 0"}},
+		{"1 << -1", vmError{"negative shift count: 1 << -1"}},
+		{"1.5 & 1", vmError{"unknown operator: FLOAT & INTEGER"}},
+		{`"a" < 1`, vmError{"type mismatch: STRING < INTEGER"}},
+		{`{[1]: 2}`, vmError{"unusable as hash key: ARRAY"}},
+		{`{1: 2}[[1]]`, vmError{"unusable as hash key: ARRAY"}},
+		{`1[0]`, vmError{"index operator not supported: INTEGER"}},
+	}
+	runVmTests(t, ts)
+}
+
+func TestCallingFunctions(t *testing.T) {
+	ts := []vmTestCase{
+		{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", 15},
+		{"let one = fn() { 1; }; let two = fn() { 2; }; one() + two()", 3},
+		{"let a = fn() { 1 }; let b = fn() { a() + 1 }; let c = fn() { b() + 1 }; c();", 3},
+		{"let earlyExit = fn() { return 99; 100; }; earlyExit();", 99},
+		{"let earlyExit = fn() { if (true) { return 99; } 100; }; earlyExit();", 99},
+		{"let noReturn = fn() { }; noReturn();", nil},
+		{"let returnsOne = fn() { 1; }; let returnsOneReturner = fn() { returnsOne; }; returnsOneReturner()();", 1},
+		{"return 10; 9;", 10},
+		{"if (10 > 1) { if (10 > 1) { return 10; } return 1; }", 10},
+	}
+	runVmTests(t, ts)
+}
+
+func TestCallingFunctionsWithBindings(t *testing.T) {
+	ts := []vmTestCase{
+		{"let one = fn() { let one = 1; one }; one();", 1},
+		{"let oneAndTwo = fn() { let one = 1; let two = 2; one + two; }; oneAndTwo();", 3},
+		{`let firstFoobar = fn() { let foobar = 50; foobar; };
+		let secondFoobar = fn() { let foobar = 100; foobar; };
+		firstFoobar() + secondFoobar();`, 150},
+		{`let globalSeed = 50;
+		let minusOne = fn() { let num = 1; globalSeed - num; };
+		let minusTwo = fn() { let num = 2; globalSeed - num; };
+		minusOne() + minusTwo();`, 97},
+		{"let x = 1; let f = fn() { let x = x + 10; x }; f() + x", 12},
+	}
+	runVmTests(t, ts)
+}
+
+func TestCallingFunctionsWithArguments(t *testing.T) {
+	ts := []vmTestCase{
+		{"let identity = fn(a) { a; }; identity(4);", 4},
+		{"let sum = fn(a, b) { a + b; }; sum(1, 2);", 3},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{"let sum = fn(a, b) { let c = a + b; c; }; let outer = fn() { sum(1, 2) + sum(3, 4); }; outer();", 10},
+		{"let add = fn(a, b) { a + b }; let applyFunc = fn(a, b, func) { func(a, b) }; applyFunc(2, 2, add);", 4},
+		{"fn(x) { x * 2 }(3)", 6},
+	}
+	runVmTests(t, ts)
+}
+
+func TestCallingFunctionsWithWrongArguments(t *testing.T) {
+	ts := []vmTestCase{
+		{"fn() { 1; }(1);", vmError{"wrong number of arguments: want=0, got=1"}},
+		{"fn(a) { a; }();", vmError{"wrong number of arguments: want=1, got=0"}},
+		{"fn(a, b) { a + b; }(1);", vmError{"wrong number of arguments: want=2, got=1"}},
+		{"let f = fn(a) { a }; let g = fn() { f(1, 2) }; g()", vmError{"wrong number of arguments: want=1, got=2"}},
+	}
+	runVmTests(t, ts)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	ts := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len([1, 2, 3])`, 3},
+		{`len(1)`, vmError{"argument to `len` not supported. got INTEGER"}},
+		{`len("one", "two")`, vmError{"wrong number of arguments. got=2, want=1"}},
+		{`first([1, 2, 3])`, 1},
+		{`last([1, 2, 3])`, 3},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`push([], 1)`, []int{1}},
+		{`let a = [1]; append!(a, 2); a`, []int{1, 2}},
+		{`let a = [1]; pop(a)`, 1},
+		{`let f = fn(s) { len(s) }; f("abc")`, 3},
+		{`let l = len; l([1])`, 1},
+	}
+	runVmTests(t, ts)
+}
+
+func TestClosures(t *testing.T) {
+	ts := []vmTestCase{
+		{"let newClosure = fn(a) { fn() { a; }; }; let closure = newClosure(99); closure();", 99},
+		{"let newAdder = fn(a, b) { fn(c) { a + b + c }; }; let adder = newAdder(1, 2); adder(8);", 11},
+		{"let newAdder = fn(a, b) { let c = a + b; fn(d) { c + d }; }; let adder = newAdder(1, 2); adder(8);", 11},
+		{`let newAdderOuter = fn(a, b) {
+			let c = a + b;
+			fn(d) { let e = d + c; fn(f) { e + f; }; };
+		};
+		let newAdderInner = newAdderOuter(1, 2);
+		let adder = newAdderInner(3);
+		adder(8);`, 14},
+		{`let a = 1;
+		let newAdderOuter = fn(b) { fn(c) { fn(d) { a + b + c + d }; }; };
+		let newAdderInner = newAdderOuter(2);
+		let adder = newAdderInner(3);
+		adder(8);`, 14},
+		{`let newClosure = fn(a, b) {
+			let one = fn() { a; };
+			let two = fn() { b; };
+			fn() { one() + two(); };
+		};
+		let closure = newClosure(9, 90);
+		closure();`, 99},
+	}
+	runVmTests(t, ts)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	ts := []vmTestCase{
+		{`let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } };
+		countDown(1);`, 0},
+		{`let wrapper = fn() {
+			let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } };
+			countDown(1);
+		};
+		wrapper();`, 0},
+		{`let fibonacci = fn(x) {
+			if (x == 0) { return 0; }
+			if (x == 1) { return 1; }
+			fibonacci(x - 1) + fibonacci(x - 2);
+		};
+		fibonacci(15);`, 610},
+		// 递归的局部闭包,同时捕获外层的参数f
+		{`let map = fn(arr, f) {
+			let map_iter = fn(arr, accumulated) {
+				if (len(arr) == 0) {
+					accumulated;
+				} else {
+					map_iter(rest(arr), push(accumulated, f(first(arr))));
+				};
+			};
+			map_iter(arr, []);
+		};
+		map([1, 2, 3], fn(x) { x * 2 });`, []int{2, 4, 6}},
+		{`let reduce = fn(arr, initial, f) {
+			let reduce_iter = fn(arr, res) {
+				if (len(arr) == 0) { res } else { reduce_iter(rest(arr), f(res, first(arr))); }
+			};
+			reduce_iter(arr, initial);
+		};
+		reduce([1, 2, 3, 4], 0, fn(acc, el) { acc + el });`, 10},
+		// 对自身的名字赋值,和解释器一样修改let定义的绑定
+		{"let f = fn() { f = 3 }; f(); f", 3},
+		{"let f = fn() { fn() { f = 1 }() }; f(); f", 1},
+		{"let g = fn() { let h = fn() { h = 4; 5 }; [h(), h] }; g()", []int{5, 4}},
+		{`let countDown = fn(x) { if (x == 0) { countDown = 7; return x; }; countDown(x - 1) };
+		[countDown(2), countDown];`, []int{0, 7}},
+	}
+	runVmTests(t, ts)
+}
+
+func TestStackOverflow(t *testing.T) {
+	program := parse("let f = fn(x) { f(x + 1) }; f(0)")
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err := vm.Run()
+	if err == nil || err.Message != "stack overflow" {
+		t.Fatalf("expected stack overflow error, got=%v", err)
+	}
+}
+
+// 重复的常量共用常量池中的下标,语句再多也不会超出OpConstant的操作数
+func TestRepeatedConstants(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"let s = 0;\n" + strings.Repeat("s = s + 1;\n", 70000) + "s", 70000},
+		{"let s = \"\";\n" + strings.Repeat("s = \"x\";\n", 70000) + "s", "x"},
+	})
+}
+
+func TestForLoops(t *testing.T) {
+	ts := []vmTestCase{
+		{"let i = 0; for (i < 5) { let i = i + 1; }; i;", 5},
+		{"let i = 0; for (false) { let i = i + 1; }; i;", 0},
+		{"let i = 0; for (true) { if (i == 3) { break; } let i = i + 1; }; i;", 3},
+		{`
+		let i = 0;
+		let sum = 0;
+		for (i < 10) {
+			let i = i + 1;
+			if (i > 5) {
+				if (true) { continue; }
+			}
+			let sum = sum + i;
+		}
+		sum;`, 15},
+		{`
+		let i = 0;
+		let count = 0;
+		for (i < 3) {
+			let i = i + 1;
+			let j = 0;
+			for (true) {
+				if (j == 2) { break; }
+				let j = j + 1;
+				let count = count + 1;
+			}
+		}
+		count;`, 6},
+		{"let f = fn() { let i = 0; for (true) { if (i == 4) { return i * 10; } let i = i + 1; } }; f();", 40},
+		{"let f = fn() { let i = 0; let sum = 0; for (i < 4) { i += 1; if (i == 2) { continue; } sum += i; }; sum }; f();", 8},
+		{"for (false) { 1 }", nil},
+		{"let i = 0; for (i < 3) { let i = i + 1; }", nil},
+		{"for (1 + true) { 1 }", vmError{"type mismatch: INTEGER + BOOLEAN"}},
+		{"let i = 0; for (i < 5) { let i = i + 1; if (i == 2) { i + true; } }", vmError{"type mismatch: INTEGER + BOOLEAN"}},
+		// 不在语句位置的break和continue是一个值,不会跳转,也不会在栈上留下操作数
+		{"let i = 0; let s = 0; for (i < 5000) { i += 1; s += 1 + if (i % 2 == 0) { continue } else { 1 } }; s", vmError{"type mismatch: INTEGER + CONTINUE"}},
+		{"let i = 0; for (i < 3) { i += 1; [1, break] }; i", 3},
+		{"let i = 0; for (i < 3) { i += 1; let x = [break, continue]; }; i", 3},
+		{"let f = fn() { let i = 0; for (i < 3) { i += 1; puts(1 + break) } }; f()", vmError{"type mismatch: INTEGER + BREAK"}},
+		{"let i = 0; for (true) { i += 1; match (i) { 3 => { break }, _ => { continue } } }; i", 3},
+		{"let i = 0; let s = 0; for (i < 4) { i += 1; if (i == 2) { match (i) { _ => continue } } s += i }; s", 8},
+	}
+	runVmTests(t, ts)
+}
+
+func TestForRangeLoops(t *testing.T) {
+	ts := []vmTestCase{
+		{"let sum = 0; for (v range [1, 2, 3]) { sum += v; }; sum;", 6},
+		{"let sum = 0; for (i, v range [10, 20, 30]) { sum += i * v; }; sum;", 80},
+		{`let s = ""; for (k, v range {"b": 2, "a": 1}) { s = s + k + "${v}"; }; s;`, "a1b2"},
+		{`let s = ""; for (k range {"b": 2, "a": 1}) { s = s + k; }; s;`, "ab"},
+		{`let s = ""; for (ch range "你好") { s = ch + s; }; s;`, "好你"},
+		{`let n = 0; for (i, ch range "你好") { n = i; }; n;`, 1},
+		{"let sum = 0; for (i range 5) { sum += i; }; sum;", 10},
+		{"let sum = 0; for (i range range(1, 10, 2)) { sum += i; }; sum;", 25},
+		{"let sum = 0; for (v range [1, 2, 3, 4]) { if (v == 3) { break; } sum += v; }; sum;", 3},
+		{"let sum = 0; for (v range [1, 2, 3, 4]) { if (v == 2) { continue; } sum += v; }; sum;", 8},
+		{"let sum = 0; for (a range [1, 2]) { for (b range [10, 20]) { if (b == 20) { break; } sum += a * b; } }; sum;", 30},
+		{"let f = fn() { for (v range [1, 2, 3]) { if (v == 2) { return v * 10; } } }; f();", 20},
+		{"let f = fn(arr) { let sum = 0; for (i, v range arr) { sum += i + v; }; sum }; f([5, 5]);", 11},
+		// 每次迭代的循环变量都是新的绑定
+		{"let fs = []; for (v range [1, 2, 3]) { append!(fs, fn() { v }); }; fs[0]() + fs[2]();", 4},
+		{"let f = fn() { let fs = []; for (v range [1, 2, 3]) { append!(fs, fn() { v }); }; fs[0]() + fs[2]() }; f();", 4},
+		// 闭包和循环体共享每次迭代的变量,闭包中可以对它赋值
+		{"let fs = []; for (i range 3) { append!(fs, fn() { i = i + 10; i }) }; [fs[0](), fs[0](), fs[1](), fs[2]()]", []int{10, 20, 11, 12}},
+		{"let f = fn() { let fs = []; for (i range 3) { append!(fs, fn() { i = i + 10; i }) }; [fs[0](), fs[0](), fs[1](), fs[2]()] }; f()", []int{10, 20, 11, 12}},
+		{"let fs = []; for (i range 3) { let f = fn() { i }; i = i * 10; append!(fs, f); }; [fs[0](), fs[1](), fs[2]()]", []int{0, 10, 20}},
+		{"let out = []; for (i range 3) { let f = fn() { i += 100 }; f(); append!(out, i); }; out", []int{100, 101, 102}},
+		{`let fs = []; for (k, v range [5, 6]) { let w = v; append!(fs, fn() { k += 1; w += 100; [k, w] }) };
+		let a = fs[0](); let b = fs[0](); let c = fs[1](); [a[0], a[1], b[0], b[1], c[0], c[1]]`, []int{1, 105, 2, 205, 2, 106}},
+		{"let fs = []; for (i range 4) { if (i % 2 == 0) { continue; } append!(fs, fn() { i += 1; i }) }; [fs[0](), fs[1]()]", []int{2, 4}},
+		{"let fs = []; for (x range [1, 2]) { match (x) { y => append!(fs, fn() { y += 1; y }) } }; [fs[0](), fs[0](), fs[1]()]", []int{2, 3, 3}},
+		{"let out = []; for (i range 2) { let f = fn() { f = i; 0 }; f(); append!(out, f); }; out", []int{0, 1}},
+		{"let v = 99; for (v range [1, 2]) { }; v;", 99},
+		{"let f = fn() { let v = 99; for (v range [1, 2]) { }; v }; f();", 99},
+		// 数组在循环中增长时,迭代次数不变
+		{"let a = [1, 2]; for (v range a) { append!(a, v); }; len(a);", 4},
+		{"for (v range []) { 1 }", nil},
+		{"for (v range true) { v }", vmError{"cannot range over BOOLEAN"}},
+	}
+	runVmTests(t, ts)
+}
+
+func TestMatchExpressions(t *testing.T) {
+	ts := []vmTestCase{
+		{`match (2) { 1 => "one", 2 => "two" }`, "two"},
+		{`match (3) { 1 => "one" }`, nil},
+		{`match ([1, [2, 3]]) { [1, [_, c]] => c }`, 3},
+		{`match ({"a": 1, "b": [2]}) { {"b": [x], "a": y} => x * 10 + y }`, 21},
+		{`match ([1, 2]) { [a, 3] => a, [b, c] if c > b => b + c }`, 3},
+		{"let f = fn(x) { match (x) { [a] => a, n if n > 1 => n * 2, _ => 0 } }; f([5]) + f(3) + f(1);", 11},
+		// 嵌套的match使用各自的隐藏变量
+		{"match (1) { a => match (2) { b => a * 10 + b } }", 12},
+		// 分支中绑定的变量每次执行都是新的
+		{"let fs = []; for (v range [1, 2]) { match (v) { x => append!(fs, fn() { x }) } }; fs[0]() + fs[1]();", 3},
+		{"let f = fn() { let fs = []; for (v range [1, 2]) { match (v) { x => append!(fs, fn() { x }) } }; fs[0]() + fs[1]() }; f();", 3},
+		{"let sum = 0; for (v range [1, 2, 3]) { match (v) { 2 => { continue }, n => { sum += n } } }; sum;", 4},
+	}
+	runVmTests(t, ts)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	ts := []vmTestCase{
+		{"let a = 5; a = 10; a;", 10},
+		{"let a = 5; a = 10;", 10},
+		{"let a = 5; a += 2; a;", 7},
+		{"let a = 5; a -= 2; a;", 3},
+		{"let a = 5; a *= 2; a;", 10},
+		{"let a = 9; a /= 2; a;", 4},
+		{"let a = 1; let b = 2; a = b = 3; a + b;", 6},
+		{"let a = 1; let f = fn() { a = 5; }; f(); a;", 5},
+		{"let a = 1; let f = fn() { let a = 2; a = 5; }; f(); a;", 1},
+		{"let f = fn(x) { x += 1; x }; f(1);", 2},
+		{"let counter = fn() { let n = 0; fn() { n += 1; n } }; let c = counter(); c(); c(); c();", 3},
+		{"let pair = fn() { let n = 0; [fn() { n += 1 }, fn() { n }] }; let p = pair(); p[0](); p[0](); p[1]();", 2},
+		{"let i = 0; for (i < 5) { i += 1; }; i;", 5},
+		{"let sum = 0; let i = 0; for (i < 4) { i += 1; sum += i; }; sum;", 10},
+	}
+	runVmTests(t, ts)
+}
+
+func TestIndexAssignExpressions(t *testing.T) {
+	ts := []vmTestCase{
+		{"let a = [1, 2, 3]; a[0] = 10; a[0];", 10},
+		{"let a = [1, 2, 3]; a[2] += 5; a[2];", 8},
+		{"let a = [1, 2, 3]; let b = a; b[1] = 7; a[1];", 7},
+		{"let a = [[1], [2]]; a[1][0] = 9; a[1][0];", 9},
+		{`let h = {"a": 1}; h["a"] = 2; h["a"];`, 2},
+		{`let h = {}; h["b"] = 3; h["b"];`, 3},
+		{"let h = {1: 2}; h[1] *= 5; h[1];", 10},
+		{"let a = [0, 0]; let f = fn(arr) { arr[0] = 1; }; f(a); a[0];", 1},
+		{"let a = [1]; a[1] = 2;", vmError{"index out of range: 1 (len 1)"}},
+		{"let a = [1]; a[-1] = 2;", vmError{"index out of range: -1 (len 1)"}},
+		{`let a = [1]; a["x"] = 2;`, vmError{"array index must be INTEGER. got STRING"}},
+		{"let h = {}; h[fn(x) { x }] = 1;", vmError{"unusable as hash key: FUNCTION"}},
+		{`let h = {}; h["k"] += 1;`, vmError{"key not found: k"}},
+		{`let s = "abc"; s[0] = "x";`, vmError{"index assignment not supported: STRING"}},
+	}
+	runVmTests(t, ts)
+}
+
+// 控制台每行单独编译执行,全局变量在行之间保留
+func TestGlobalsAcrossPrograms(t *testing.T) {
+	symbolTable := compiler.NewSymbolTableWithBuiltins()
+	constants := []object.Object{}
+	globals := make([]object.Object, GlobalsSize)
+
+	run := func(input string) object.Object {
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+
+		vm := NewWithGlobals(bytecode, globals)
+		if err := vm.Run(); err != nil {
+			return err
+		}
+		return vm.LastPoppedStackElem()
+	}
+
+	ts := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = 1; a", 1},
+		{"let add = fn(x) { x + a }; add(1)", 2},
+		{"add(2)", 3},
+		{"a = 10; add(2)", 12},
+		{"let b = a + true;", vmError{"type mismatch: INTEGER + BOOLEAN"}},
+		{"b", vmError{"uninitialized global variable: b"}},
+		{"if (false) { let c = 1; }; c", vmError{"uninitialized global variable: c"}},
+	}
+	for _, tt := range ts {
+		testExpectedObject(t, tt.input, "vm", tt.expected, run(tt.input))
+	}
+}
+
+// 虚拟机根据调试信息报告出错位置,和解释器一致
+// 没有执行的let定义的局部绑定,读取或通过闭包访问时报错而不是得到nil
+func TestUninitializedLocals(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn() { if (false) { let y = 1; }; y }; f()", "uninitialized local variable: y"},
+		{"let f = fn() { if (false) { let y = 1; }; fn() { y } }; f()()", "uninitialized local variable: y"},
+		{"let f = fn() { if (false) { let y = 1; }; fn() { y = 2 } }; f()()", "uninitialized local variable: y"},
+		{"let f = fn(a) { if (false) { let b = 1; }; [a, b] }; f(1)", "uninitialized local variable: b"},
+	}
+	for _, tt := range ts {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+		if err == nil || err.Message != tt.expected {
+			t.Errorf("%q: expected %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	ts := []string{
+		"5 + true",
+		"\n  1 + 2 * -true",
+		"let f = fn(x) {\n  x + true\n};\nf(1)",
+		`let a = [1]; a["x"]`,
+		"let a = [1];\na[5] = 1;",
+		"range(0, 5, 0)",
+		"fn(x) { x }()",
+		"let x = 1; x()",
+		"for (v range true) { v }",
+		"let f = fn() { let i = 0; for (i < 3) { i += 1; if (i == 2) { i + \"a\" } } }; f()",
+	}
+	for _, input := range ts {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+		if err == nil {
+			t.Errorf("%q: expected error", input)
+			continue
+		}
+
+		evaluated := evaluator.Eval(parse(input), object.NewEnvironment())
+		if err.Inspect() != evaluated.Inspect() {
+			t.Errorf("%q: wrong error. want=%q, got=%q", input, evaluated.Inspect(), err.Inspect())
+		}
+	}
+}
+
+// 编码为.malc再解码后执行,结果和报错位置不变
+func TestRunDecodedBytecode(t *testing.T) {
+	ts := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let counter = fn() { let n = 0; fn() { n += 1; n } }; let c = counter(); c(); c();", 2},
+		{`let s = ""; for (k, v range {"b": 2.5, "a": 1}) { s = s + k + "${v}"; }; s;`, "a1b2.5"},
+		{"let f = fn(x) {\n  x + true\n};\nf(1)", "ERROR: 2:5: type mismatch: INTEGER + BOOLEAN"},
+		{"if (false) { let c = 1; }; c", "ERROR: 1:28: uninitialized global variable: c"},
+		{"let f = fn() { if (false) { let y = 1; }; y }; f()", "ERROR: 1:43: uninitialized local variable: y"},
+		{"let fs = []; for (i range 3) { append!(fs, fn() { i = i + 10; i }) }; fs[1]() + fs[1]()", 32},
+		{`len("abc") + len([1])`, 4},
+	}
+	for _, tt := range ts {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		data, err := compiler.EncodeBytecode(comp.Bytecode(), true)
+		if err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+		bytecode, err := compiler.DecodeBytecode(data)
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+
+		vm := New(bytecode)
+		if err := vm.Run(); err != nil {
+			if err.Inspect() != tt.expected {
+				t.Errorf("%q: wrong error. want=%v, got=%q", tt.input, tt.expected, err.Inspect())
+			}
+			continue
+		}
+		testExpectedObject(t, tt.input, "vm", tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+// 虚拟机中的函数和解释器显示相同的源码,不包含内存地址
+// 通过检查的文件中栈上的值仍然可能是错误的类型,运行时报错而不是崩溃
+func TestInvalidStackValues(t *testing.T) {
+	concat := func(ins ...[]byte) code.Instructions {
+		out := code.Instructions{}
+		for _, in := range ins {
+			out = append(out, in...)
+		}
+		return out
+	}
+	ts := []struct {
+		instructions code.Instructions
+		expected     interface{}
+	}{
+		{concat(code.Make(code.OpNull), code.Make(code.OpIterNext, 4)), vmError{"not an iterator: NULL"}},
+		{concat(code.Make(code.OpNull), code.Make(code.OpNull), code.Make(code.OpNull), code.Make(code.OpModule, 0, 2), code.Make(code.OpPop)),
+			vmError{"module member name must be STRING. got NULL"}},
+		{concat(code.Make(code.OpNull), code.Make(code.OpConstant, 0), code.Make(code.OpMatchKey), code.Make(code.OpPop)), false},
+	}
+	for _, tt := range ts {
+		vm := New(&compiler.Bytecode{Instructions: tt.instructions, Constants: []object.Object{&object.String{Value: "m"}}})
+		var result object.Object
+		if err := vm.Run(); err != nil {
+			result = err
+		} else {
+			result = vm.LastPoppedStackElem()
+		}
+		testExpectedObject(t, tt.instructions.String(), "vm", tt.expected, result)
+	}
+}
+
+func TestFunctionInspect(t *testing.T) {
+	ts := []string{
+		"fn(x) { x + 2; };",
+		"let add = fn(a, b) { a + b }; add",
+		"fn() { fn(y) { y } }()",
+	}
+	for _, input := range ts {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err.Inspect())
+		}
+
+		evaluated := evaluator.Eval(parse(input), object.NewEnvironment())
+		if got := vm.LastPoppedStackElem().Inspect(); got != evaluated.Inspect() {
+			t.Errorf("%q: wrong inspect. want=%q, got=%q", input, evaluated.Inspect(), got)
+		}
+	}
+}
+
+// 以dir/main.mal的身份运行input,宏在编译前展开,编译错误也作为错误返回
+func runFile(dir, input string) object.Object {
+	program := parser.New(lexer.NewWithFile(filepath.Join(dir, "main.mal"), input)).ParseProgram()
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, expandErr := evaluator.ExpandMacros(program, macroEnv)
+	if expandErr != nil {
+		return expandErr
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(expanded); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		return err
+	}
+	return vm.LastPoppedStackElem()
+}
+
+// 和解释器一样运行use导入的模块
+func TestUseModules(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"math.mal":        `let double = fn(x) { x * 2 };`,
+		"lib/strings.mal": `use helper; let greet = fn(n) { helper.prefix + n };`,
+		"lib/helper.mal":  `let prefix = "hi ";`,
+		"state.mal":       `let items = []; let _hidden = 1;`,
+		"log1.mal":        `let entries = [];`,
+		"log2.mal":        `let entries = [];`,
+		"log3.mal":        `let entries = [];`,
+		"counter.mal":     `use log1; append!(log1.entries, "counter"); let n = len(log1.entries);`,
+		"once.mal":        `use log2; append!(log2.entries, "once"); let n = len(log2.entries);`,
+		"lazy.mal":        `use log3; append!(log3.entries, "lazy"); let n = len(log3.entries);`,
+		"stdcopy.mal":     `export let before = sum([1, 2]); sum = fn(arr) { 0 }; export let after = sum([1, 2]);`,
+		"usesstd.mal":     `let total = reduce(map([1, 2], fn(x) { x * 2 }), 0, fn(a, b) { a + b });`,
+		"closures.mal":    `let count = 0; let inc = fn() { count += 1; count }; let twice = fn() { inc(); inc() };`,
+		"exports.mal":     `let helper = fn(x) { x + 1 }; export let inc = fn(x) { helper(x) };`,
+		"macros.mal":      `export let unless = macro(c, a, b) { quote(if (!(unquote(c))) { unquote(a) } else { unquote(b) }) };`,
+		"broken.mal":      `let x = ;`,
+		"failing.mal":     "let a = 1;\nlet x = a + true;",
+		"cycle/a.mal":     `use b; let a = 1;`,
+		"cycle/b.mal":     `use a; let b = 1;`,
+		"matching.mal":    `let kind = fn(v) { match (v) { [] => "empty", [_] => "one", _ => "many" } };`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ts := []string{
+		`use math; math.double(4)`,
+		`use "lib/strings"; strings.greet("mal")`,
+		`use math as m; m.double(5)`,
+		`use math { double }; double(6)`,
+		`use math`,
+		`use state; append!(state.items, 1); use state; len(state.items)`,
+		`use state; state._hidden`,
+		`use state { _hidden }`,
+		`use log1; append!(log1.entries, "first"); use counter; log1.entries`,
+		// 模块只运行一次,函数中的use也一样;解释器缓存了运行过的模块,所以每个模块只在一个程序中使用
+		`let f = fn() { use once; once.n }; f() + f()`,
+		`if (false) { use lazy }; use lazy; lazy.n`,
+		// 模块使用标准库的副本,修改不影响导入者和标准库模块
+		`use stdcopy; let sum = fn(arr) { 100 }; use std; [stdcopy.before, stdcopy.after, std.sum([1, 2]), sum([])]`,
+		`use usesstd; usesstd.total`,
+		`use usesstd; usesstd.map`,
+		`use closures; closures.twice() + closures.inc()`,
+		`use exports; exports.inc(1)`,
+		`use exports; exports.helper(1)`,
+		`use exports { inc }; inc(2)`,
+		`use macros; macros.unless(false, 1, 2)`,
+		`use macros { unless }; unless(true, 1, 2)`,
+		`use matching; matching.kind([]) + matching.kind([1]) + matching.kind([1, 2])`,
+		`use std { sum }; sum([4, 5])`,
+		`use std as s; s.reduce(s.map([1, 2], fn(x) { x * 2 }), 0, fn(a, b) { a + b })`,
+		`1.x`,
+		`use nope`,
+		`use broken`,
+		`use failing`,
+		`use "cycle/a"`,
+	}
+	for _, input := range ts {
+		result := runFile(dir, input)
+
+		l := lexer.NewWithFile(filepath.Join(dir, "main.mal"), input)
+		program := parser.New(l).ParseProgram()
+		env := object.NewEnvironment()
+		evaluator.DefineMacros(program, env)
+		expanded, err := evaluator.ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("expand error for %s: %s", input, err.Inspect())
+		}
+		evaluated := evaluator.Eval(expanded, env)
+
+		if result.Inspect() != evaluated.Inspect() {
+			t.Errorf("%q: want=%q, got=%q", input, evaluated.Inspect(), result.Inspect())
+		}
+	}
+}
+
+// 模块有自己的根符号表,引用导入者的全局变量在编译时报错,不论导入者是否定义了这个名字
+func TestModuleNamespaces(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"reader.mal": "let read = fn() {\n  secret\n};",
+		"writer.mal": "let write = fn() {\n  secret = 99\n};",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ts := []struct {
+		input    string
+		expected string
+	}{
+		{`let secret = 1; use reader; reader.read()`, "reader.mal:2:3: undefined variable secret"},
+		{`use reader; reader.read()`, "reader.mal:2:3: undefined variable secret"},
+		{`let secret = 1; use writer; writer.write(); secret`, "writer.mal:2:10: assignment to undeclared variable: secret"},
+		{`use writer; writer.write()`, "writer.mal:2:10: assignment to undeclared variable: secret"},
+	}
+	for _, tt := range ts {
+		result := runFile(dir, tt.input)
+		expected := "ERROR: " + filepath.Join(dir, tt.expected)
+		if result.Inspect() != expected {
+			t.Errorf("%q: want=%q, got=%q", tt.input, expected, result.Inspect())
+		}
+	}
+}
+
+// 解释器测试中的程序(表格中每一行的第一个字符串和直接求值的字符串),从evaluator_test.go的源码中提取
+func evaluatorTestPrograms(t *testing.T) []string {
+	t.Helper()
+
+	file, err := goparser.ParseFile(gotoken.NewFileSet(), "../evaluator/evaluator_test.go", nil, 0)
+	if err != nil {
+		t.Fatalf("cannot parse evaluator tests: %s", err)
+	}
+	programs := []string{}
+	add := func(expr goast.Expr) {
+		if lit, ok := expr.(*goast.BasicLit); ok && lit.Kind == gotoken.STRING {
+			program, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				t.Fatalf("cannot unquote %s: %s", lit.Value, err)
+			}
+			programs = append(programs, program)
+		}
+	}
+	goast.Inspect(file, func(node goast.Node) bool {
+		switch node := node.(type) {
+		case *goast.CompositeLit:
+			// 表格的行: {输入, 期望值}
+			if _, ok := node.Type.(*goast.ArrayType); ok {
+				for _, el := range node.Elts {
+					if row, ok := el.(*goast.CompositeLit); ok && row.Type == nil && len(row.Elts) >= 2 {
+						add(row.Elts[0])
+					}
+				}
+			}
+		case *goast.AssignStmt:
+			// input := "..."
+			if ident, ok := node.Lhs[0].(*goast.Ident); ok && ident.Name == "input" && len(node.Rhs) == 1 {
+				add(node.Rhs[0])
+			}
+		}
+		return true
+	})
+	if len(programs) < 100 {
+		t.Fatalf("too few programs extracted from evaluator tests: %d", len(programs))
+	}
+	return programs
+}
+
+// 编译器在编译期报告、解释器在运行时才发现(或者不会执行到)的错误
+var compileTimeErrors = map[string]string{
+	"false && foobar": "1:10: undefined variable foobar",
+	"true || foobar":  "1:9: undefined variable foobar",
+	"let f = fn() { break; }; for (true) { f(); }": "1:16: break outside of loop",
+}
+
+// 解释器的测试程序在虚拟机上运行,结果和解释器相同
+// 编译期报告的错误要和解释器运行时报告的错误一致
+func TestEvaluatorPrograms(t *testing.T) {
+	for _, input := range evaluatorTestPrograms(t) {
+		evaluated := evaluator.Eval(parse(input), object.NewEnvironment())
+
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			if expected, ok := compileTimeErrors[input]; ok && err.Error() == expected {
+				continue
+			}
+			got := "ERROR: " + strings.Replace(err.Error(), "undefined variable", "identifier not found:", 1)
+			if evaluated == nil || got != evaluated.Inspect() {
+				t.Errorf("%q: compiler error: %s", input, err)
+			}
+			continue
+		}
+		vm := New(comp.Bytecode())
+		var result object.Object
+		if err := vm.Run(); err != nil {
+			result = err
+		} else {
+			result = vm.LastPoppedStackElem()
+		}
+
+		// 以let语句结束的程序没有值
+		if evaluated == nil {
+			if err, ok := result.(*object.Error); ok {
+				t.Errorf("%q: vm error: %s", input, err.Inspect())
+			}
+			continue
+		}
+		if result.Inspect() != evaluated.Inspect() {
+			t.Errorf("%q: want=%q, got=%q", input, evaluated.Inspect(), result.Inspect())
+		}
+	}
+}